@@ -0,0 +1,392 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// DocServerSpec defines the desired state of DocServer
+type DocServerSpec struct {
+	// ClassName is the name of DocServerClass that gives the default properties.
+	// The default class is used if not set.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+
+	// Source is where the sources of the document are pulled from.
+	// +kubebuilder:validation:Required
+	Source Source `json:"source"`
+
+	// Replicas is the number of docserver pod.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Server is the properties of the docserver pods that serve the document.
+	// +optional
+	Server Server `json:"server,omitempty"`
+
+	// Sync is the properties of the gitpod pods that sync the sources.
+	// +optional
+	Sync Sync `json:"sync,omitempty"`
+
+	// Storage is the properties of persistenVolumeClaim.
+	// +optional
+	Storage Storage `json:"storage,omitempty"`
+
+	// Autoscaling is the properties of HorizontalPodAutoscaler that scales the docserver pods.
+	// Replicas is ignored if set.
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+
+	// PodDisruptionBudget is the properties of PodDisruptionBudget of the docserver pods.
+	// PodDisruptionBudget with maxUnavailable 1 is created by default when more than one pod runs.
+	// +optional
+	PodDisruptionBudget *PodDisruptionBudget `json:"podDisruptionBudget,omitempty"`
+
+	// ImagePullSecrets is the list of secrets used to pull the images of the docserver and gitpod pods.
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// ServiceAccountName is the name of ServiceAccount used by the docserver and gitpod pods.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// Source defines where the sources of the document are pulled from.
+type Source struct {
+	// Git is the git repository where the sources of the document are stored.
+	// +kubebuilder:validation:Required
+	Git GitSource `json:"git"`
+}
+
+// GitSource defines the git repository and how to pull it.
+type GitSource struct {
+	// URL is the url of git repository where the sources of the document are stored.
+	// The url can be https, http, ssh, git or file url, or scp-like ssh url such as git@github.com:org/repo.git.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	URL string `json:"url"`
+
+	// Branch is the branch name to be pulled.
+	// +kubebuilder:default=main
+	// +optional
+	Branch string `json:"branch,omitempty"`
+
+	// Depth is the depth to create shallow clone.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Depth int `json:"depth,omitempty"`
+
+	// LFS is the flag whether or not to fetch the files stored in Git LFS.
+	// +optional
+	LFS bool `json:"lfs,omitempty"`
+
+	// Submodules is the properties of git submodules. The submodules are not fetched if not set.
+	// +optional
+	Submodules *Submodules `json:"submodules,omitempty"`
+
+	// Auth is the credentials used to pull the sources from the repository.
+	// +optional
+	Auth GitAuth `json:"auth,omitempty"`
+
+	// TLS is the properties of tls used to pull the sources from the repository.
+	// +optional
+	TLS GitTLS `json:"tls,omitempty"`
+}
+
+// Submodules defines how to fetch git submodules.
+// The submodules are fetched with the same credentials as the repository.
+type Submodules struct {
+	// Recursive is the flag whether or not to fetch the nested submodules.
+	// +kubebuilder:default=true
+	// +optional
+	Recursive *bool `json:"recursive,omitempty"`
+
+	// Shallow is the flag whether or not to clone the submodules with depth 1.
+	// +kubebuilder:default=true
+	// +optional
+	Shallow *bool `json:"shallow,omitempty"`
+}
+
+// GitAuth defines the credentials of the repository. Only one of basicAuthSecret and ssh can be set.
+type GitAuth struct {
+	// BasicAuthSecret is the name of secret where username and password used for basic authentication are stored.
+	// +optional
+	BasicAuthSecret string `json:"basicAuthSecret,omitempty"`
+
+	// SSH is the properties of ssh used to pull the sources from the repository.
+	// +optional
+	SSH *SSHAuth `json:"ssh,omitempty"`
+}
+
+// SSHAuth defines the ssh config, private key and known hosts.
+type SSHAuth struct {
+	// ConfigMap is the name of configmap where ssh config is stored.
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+
+	// PrivateKeySecret is the name of secret where ssh private-key is stored.
+	// The secret can also hold the known hosts under the key known_hosts.
+	// +optional
+	PrivateKeySecret string `json:"privateKeySecret,omitempty"`
+
+	// KnownHosts is the known hosts used to verify the host key of the repository.
+	// +optional
+	KnownHosts *KnownHosts `json:"knownHosts,omitempty"`
+
+	// StrictHostKeyChecking is the flag whether or not to refuse to connect to the hosts whose key is not in the known hosts.
+	// +kubebuilder:default=true
+	// +optional
+	StrictHostKeyChecking *bool `json:"strictHostKeyChecking,omitempty"`
+}
+
+// KnownHosts defines the source of the ssh known hosts.
+type KnownHosts struct {
+	// Inline is the content of known_hosts.
+	// +optional
+	Inline string `json:"inline,omitempty"`
+
+	// ConfigMap is the name of configmap where known_hosts is stored under the key known_hosts.
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+}
+
+// GitTLS defines how to verify the certificate of the repository.
+type GitTLS struct {
+	// Verify is the flag whether or not to verify the certificate of the repository.
+	// +kubebuilder:default=true
+	// +optional
+	Verify *bool `json:"verify,omitempty"`
+
+	// CASecret is the name of secret where the CA certificate is stored under the key ca.crt.
+	// +optional
+	CASecret string `json:"caSecret,omitempty"`
+}
+
+// Server defines the properties of the docserver pods.
+type Server struct {
+	// Image is the name:tag of the image used by the docserver container.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// Sync defines the properties of the gitpod pods.
+type Sync struct {
+	// Image is the name:tag of the image used by the gitpod container.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+type Storage struct {
+	// Size is the volume capacity requested by persistenVolumeClaim.
+	// +optional
+	Size string `json:"size,omitempty"`
+
+	// StorageClass is StorageClassName of persistenVolumeClaim.
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// BlockOwnerDeletion is deprecated and has no effect.
+	// The persistenVolumeClaim is handled according to RetentionPolicy when the DocServer is deleted.
+	// +optional
+	BlockOwnerDeletion *bool `json:"blockOwnerDeletion,omitempty"`
+
+	// RetentionPolicy is what to do with persistenVolumeClaim when the DocServer is deleted.
+	// Delete deletes it, Retain leaves it labeled with the name of the DocServer for reuse,
+	// and Snapshot creates VolumeSnapshot of it before deleting it.
+	// +kubebuilder:default=Delete
+	// +optional
+	RetentionPolicy RetentionPolicy `json:"retentionPolicy,omitempty"`
+
+	// SnapshotClassName is VolumeSnapshotClassName of VolumeSnapshot created by Snapshot retention policy.
+	// The default VolumeSnapshotClass is used if not set.
+	// +optional
+	SnapshotClassName string `json:"snapshotClassName,omitempty"`
+
+	// AccessModes is the access modes of persistenVolumeClaim. ReadWriteMany is used if not set.
+	// The gitpod and docserver pods are scheduled on the same node unless ReadWriteMany or ReadOnlyMany is included,
+	// and the docserver pods are stopped while the gitpod pod runs if ReadWriteOncePod is included.
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// VolumeMode is the volume mode of persistenVolumeClaim. Filesystem is used if not set.
+	// With Block, the volume is attached to the gitpod and docserver containers as the raw block device /dev/docs
+	// instead of being mounted at /docs, so the images have to handle the device.
+	// +kubebuilder:validation:Enum=Filesystem;Block
+	// +optional
+	VolumeMode *corev1.PersistentVolumeMode `json:"volumeMode,omitempty"`
+
+	// Labels is the labels added to persistenVolumeClaim.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations is the annotations added to persistenVolumeClaim.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Selector is the label query over the persistent volumes to bind to persistenVolumeClaim.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// ExistingClaim is the name of persistenVolumeClaim in the namespace of the DocServer used instead of
+	// the one created by the controller. The other properties of the storage are ignored if set.
+	// +optional
+	ExistingClaim string `json:"existingClaim,omitempty"`
+
+	// VolumeSource is the volume, such as NFS, used instead of persistenVolumeClaim created by the controller.
+	// The other properties of the storage are ignored if set.
+	// +optional
+	VolumeSource *corev1.VolumeSource `json:"volumeSource,omitempty"`
+}
+
+// RetentionPolicy is what to do with persistenVolumeClaim when the DocServer is deleted.
+// +kubebuilder:validation:Enum=Delete;Retain;Snapshot
+type RetentionPolicy string
+
+const (
+	RetentionPolicyDelete   = RetentionPolicy("Delete")
+	RetentionPolicyRetain   = RetentionPolicy("Retain")
+	RetentionPolicySnapshot = RetentionPolicy("Snapshot")
+)
+
+// IsExternal returns whether or not the storage is given by existingClaim or volumeSource
+// instead of persistenVolumeClaim created by the controller.
+func (s *Storage) IsExternal() bool {
+	return len(s.ExistingClaim) != 0 || s.VolumeSource != nil
+}
+
+// Autoscaling defines HorizontalPodAutoscaler of the docserver pods.
+// The CPU utilization is targeted at 80% if neither targetCPUUtilizationPercentage nor requestsPerSecond is set.
+type Autoscaling struct {
+	// MinReplicas is the lower limit of the number of docserver pods.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit of the number of docserver pods.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilizationPercentage is the target average CPU utilization of the docserver pods
+	// in percentage of the requested CPU.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+
+	// RequestsPerSecond is the target of the requests per second served by each docserver pod,
+	// which needs the custom metrics API such as prometheus-adapter.
+	// +optional
+	RequestsPerSecond *RequestsPerSecond `json:"requestsPerSecond,omitempty"`
+}
+
+// PodDisruptionBudget defines PodDisruptionBudget of the docserver pods. Only one of minAvailable and maxUnavailable can be set.
+type PodDisruptionBudget struct {
+	// Enabled is the flag whether or not to create PodDisruptionBudget.
+	// It is enabled if replicas or minReplicas of autoscaling is greater than 1 when not set.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// MinAvailable is the number or percentage of the docserver pods that must be available after an eviction.
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// MaxUnavailable is the number or percentage of the docserver pods that can be unavailable after an eviction.
+	// It is 1 if neither minAvailable nor maxUnavailable is set.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// RequestsPerSecond defines the custom metric of the requests per second.
+type RequestsPerSecond struct {
+	// MetricName is the name of the pods metric in the custom metrics API.
+	// +kubebuilder:default=http_requests_per_second
+	// +optional
+	MetricName string `json:"metricName,omitempty"`
+
+	// TargetAverageValue is the target value of the metric averaged over the docserver pods, such as 10 or 500m.
+	// +kubebuilder:validation:MinLength=1
+	TargetAverageValue string `json:"targetAverageValue"`
+}
+
+// DocServerPhase is the overall state of DocServer.
+// +kubebuilder:validation:Enum=NotReady;Available;Healthy
+type DocServerPhase string
+
+const (
+	DocServerNotReady  = DocServerPhase("NotReady")
+	DocServerAvailable = DocServerPhase("Available")
+	DocServerHealthy   = DocServerPhase("Healthy")
+)
+
+// DocServerStatus defines the observed state of DocServer
+type DocServerStatus struct {
+	// Phase is the overall state of the DocServer.
+	// +optional
+	Phase DocServerPhase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the generation of the DocServer observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Commit is the SHA of the commit synced by the last successful gitpod Job.
+	// +optional
+	Commit string `json:"commit,omitempty"`
+
+	// Conditions is the latest observations of the state of the DocServer.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="REPLICAS",type="integer",JSONPath=".spec.replicas"
+// +kubebuilder:printcolumn:name="STATUS",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:printcolumn:name="BRANCH",type="string",JSONPath=".spec.source.git.branch",priority=1
+// +kubebuilder:printcolumn:name="URL",type="string",JSONPath=".spec.source.git.url",priority=1
+// +kubebuilder:printcolumn:name="COMMIT",type="string",JSONPath=".status.commit",priority=1
+
+// DocServer is the Schema for the docservers API
+type DocServer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DocServerSpec   `json:"spec,omitempty"`
+	Status DocServerStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DocServerList contains a list of DocServer
+type DocServerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DocServer `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DocServer{}, &DocServerList{})
+}
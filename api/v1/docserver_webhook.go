@@ -0,0 +1,412 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/git-ogawa/docserver/internal/features"
+	"github.com/git-ogawa/docserver/internal/giturl"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var docserverlog = logf.Log.WithName("docserver-resource")
+
+// webhookClient reads the DocServerClasses and StorageClasses referenced by the DocServers.
+// The checks that need them are skipped if it is not set.
+var webhookClient client.Reader
+
+func (r *DocServer) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	webhookClient = mgr.GetAPIReader()
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-update-git-ogawa-github-io-v1-docserver,mutating=true,failurePolicy=fail,sideEffects=None,groups=update.git-ogawa.github.io,resources=docservers,verbs=create;update,versions=v1,name=mdocserver.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &DocServer{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *DocServer) Default() {
+	docserverlog.Info("default", "name", r.Name)
+
+	git := &r.Spec.Source.Git
+	if len(git.Branch) == 0 {
+		git.Branch = "main"
+	}
+
+	if git.TLS.Verify == nil {
+		verify := true
+		git.TLS.Verify = &verify
+	}
+
+	if git.Depth <= 0 {
+		git.Depth = 1
+	}
+
+	if git.Submodules != nil {
+		if git.Submodules.Recursive == nil {
+			recursive := true
+			git.Submodules.Recursive = &recursive
+		}
+		if git.Submodules.Shallow == nil {
+			shallow := true
+			git.Submodules.Shallow = &shallow
+		}
+	}
+
+	if git.Auth.SSH != nil && git.Auth.SSH.StrictHostKeyChecking == nil {
+		strict := true
+		git.Auth.SSH.StrictHostKeyChecking = &strict
+	}
+
+	if r.Spec.Autoscaling != nil {
+		if r.Spec.Autoscaling.MinReplicas == nil {
+			minReplicas := int32(1)
+			r.Spec.Autoscaling.MinReplicas = &minReplicas
+		}
+		if rps := r.Spec.Autoscaling.RequestsPerSecond; rps != nil && len(rps.MetricName) == 0 {
+			rps.MetricName = "http_requests_per_second"
+		}
+	}
+
+	if len(r.Spec.Storage.RetentionPolicy) == 0 {
+		r.Spec.Storage.RetentionPolicy = RetentionPolicyDelete
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-update-git-ogawa-github-io-v1-docserver,mutating=false,failurePolicy=fail,sideEffects=None,groups=update.git-ogawa.github.io,resources=docservers,verbs=create;update,versions=v1,name=vdocserver.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &DocServer{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *DocServer) ValidateCreate() error {
+	docserverlog.Info("validate create", "name", r.Name)
+
+	errs := r.validate()
+	errs = append(errs, r.validateFeatureGates(nil)...)
+	return r.toAggregate(errs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *DocServer) ValidateUpdate(old runtime.Object) error {
+	docserverlog.Info("validate update", "name", r.Name)
+
+	// The finalizer must be removable even if the DocServer does not pass the current validation.
+	if !r.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	errs := r.validate()
+	if oldDs, ok := old.(*DocServer); ok {
+		errs = append(errs, r.validateUpdate(oldDs)...)
+		errs = append(errs, r.validateFeatureGates(oldDs)...)
+	}
+	return r.toAggregate(errs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *DocServer) ValidateDelete() error {
+	docserverlog.Info("validate delete", "name", r.Name)
+
+	return nil
+}
+
+// ValidateSpec validates the DocServer as the webhook does on creation, except the feature gates.
+// The controller uses it to reject the DocServers created while the webhook was disabled.
+func (r *DocServer) ValidateSpec() error {
+	return r.toAggregate(r.validate())
+}
+
+// The prefixes of the names of the resources created for the DocServer.
+var childNamePrefixes = []string{"docserver-", "gitpod-"}
+
+// imageReferenceRegexp matches the image references such as registry.example.com:5000/org/image:tag@sha256:digest.
+var imageReferenceRegexp = func() *regexp.Regexp {
+	const (
+		alphaNumeric    = `[a-z0-9]+`
+		separator       = `(?:[._]|__|[-]*)`
+		nameComponent   = alphaNumeric + `(?:` + separator + alphaNumeric + `)*`
+		domainComponent = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+		domain          = domainComponent + `(?:\.` + domainComponent + `)*(?::[0-9]+)?`
+		name            = `(?:` + domain + `/)?` + nameComponent + `(?:/` + nameComponent + `)*`
+		tag             = `[\w][\w.-]{0,127}`
+		digest          = `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}`
+	)
+	return regexp.MustCompile(`^` + name + `(?::` + tag + `)?(?:@` + digest + `)?$`)
+}()
+
+func (r *DocServer) validate() field.ErrorList {
+	var errs field.ErrorList
+
+	for _, prefix := range childNamePrefixes {
+		for _, msg := range validation.IsDNS1035Label(prefix + r.Name) {
+			errs = append(errs, field.Invalid(field.NewPath("metadata", "name"), r.Name, fmt.Sprintf("%s%s is used as the name of the child resources: %s", prefix, r.Name, msg)))
+		}
+	}
+
+	if r.Spec.Replicas < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("spec", "replicas"), r.Spec.Replicas, "Replicas must not be negative."))
+	}
+
+	imagePaths := []struct {
+		path  *field.Path
+		image string
+	}{
+		{field.NewPath("spec", "server", "image"), r.Spec.Server.Image},
+		{field.NewPath("spec", "sync", "image"), r.Spec.Sync.Image},
+	}
+	for _, p := range imagePaths {
+		if len(p.image) != 0 && !imageReferenceRegexp.MatchString(p.image) {
+			errs = append(errs, field.Invalid(p.path, p.image, "Image must be a valid image reference."))
+		}
+	}
+
+	if len(r.Spec.Storage.Size) != 0 {
+		if _, err := resource.ParseQuantity(r.Spec.Storage.Size); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "storage", "size"), r.Spec.Storage.Size, err.Error()))
+		}
+	}
+
+	if len(r.Spec.Storage.ExistingClaim) != 0 && r.Spec.Storage.VolumeSource != nil {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "storage", "volumeSource"), "Only one of existingClaim and volumeSource can be set."))
+	}
+
+	for _, mode := range r.Spec.Storage.AccessModes {
+		if mode == corev1.ReadWriteOncePod && r.Spec.Replicas > 1 {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "replicas"), r.Spec.Replicas, "Replicas must be at most 1 when accessModes includes ReadWriteOncePod."))
+		}
+		if mode == corev1.ReadWriteOncePod && r.Spec.Autoscaling != nil {
+			errs = append(errs, field.Forbidden(field.NewPath("spec", "autoscaling"), "Autoscaling cannot be set when accessModes includes ReadWriteOncePod."))
+		}
+	}
+
+	errs = append(errs, r.validateAutoscaling()...)
+
+	if pdb := r.Spec.PodDisruptionBudget; pdb != nil && pdb.MinAvailable != nil && pdb.MaxUnavailable != nil {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "podDisruptionBudget", "maxUnavailable"), "Only one of minAvailable and maxUnavailable can be set."))
+	}
+
+	errs = append(errs, r.validateSource()...)
+	return errs
+}
+
+func (r *DocServer) validateSource() field.ErrorList {
+	var errs field.ErrorList
+	git := r.Spec.Source.Git
+	path := field.NewPath("spec", "source", "git")
+
+	gitURL, err := giturl.Parse(git.URL)
+	if err != nil {
+		errs = append(errs, field.Invalid(path.Child("url"), git.URL, err.Error()))
+	}
+
+	if git.Depth < 1 {
+		errs = append(errs, field.Invalid(path.Child("depth"), git.Depth, "Depth must be at least 1."))
+	}
+
+	if len(git.Auth.BasicAuthSecret) != 0 && git.Auth.SSH != nil {
+		errs = append(errs, field.Forbidden(path.Child("auth", "ssh"), "Only one of basicAuthSecret and ssh can be set."))
+	}
+
+	if ssh := git.Auth.SSH; ssh != nil {
+		if gitURL != nil && !gitURL.IsSSH() {
+			errs = append(errs, field.Invalid(path.Child("url"), git.URL, "Url must be ssh url when ssh is set."))
+		}
+		if knownHosts := ssh.KnownHosts; knownHosts != nil {
+			if len(knownHosts.Inline) != 0 && len(knownHosts.ConfigMap) != 0 {
+				errs = append(errs, field.Invalid(path.Child("auth", "ssh", "knownHosts"), knownHosts.ConfigMap, "Only one of inline and configMap can be set."))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (r *DocServer) validateAutoscaling() field.ErrorList {
+	var errs field.ErrorList
+	autoscaling := r.Spec.Autoscaling
+	path := field.NewPath("spec", "autoscaling")
+
+	if autoscaling == nil {
+		return errs
+	}
+	if autoscaling.MinReplicas != nil && *autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		errs = append(errs, field.Invalid(path.Child("minReplicas"), *autoscaling.MinReplicas, "MinReplicas must not be greater than maxReplicas."))
+	}
+	if rps := autoscaling.RequestsPerSecond; rps != nil {
+		if _, err := resource.ParseQuantity(rps.TargetAverageValue); err != nil {
+			errs = append(errs, field.Invalid(path.Child("requestsPerSecond", "targetAverageValue"), rps.TargetAverageValue, err.Error()))
+		}
+	}
+
+	return errs
+}
+
+// validateFeatureGates rejects the fields of the disabled features. The fields already set on the old object
+// are allowed so that the existing DocServers can still be updated after the feature is disabled.
+func (r *DocServer) validateFeatureGates(old *DocServer) field.ErrorList {
+	var errs field.ErrorList
+
+	if !features.Enabled(features.SnapshotRetention) && r.Spec.Storage.RetentionPolicy == RetentionPolicySnapshot &&
+		(old == nil || old.Spec.Storage.RetentionPolicy != RetentionPolicySnapshot) {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "storage", "retentionPolicy"),
+			fmt.Sprintf("The Snapshot retention policy requires the %s feature gate.", features.SnapshotRetention)))
+	}
+
+	usesRequestsPerSecond := func(ds *DocServer) bool {
+		return ds.Spec.Autoscaling != nil && ds.Spec.Autoscaling.RequestsPerSecond != nil
+	}
+	if !features.Enabled(features.CustomMetricsAutoscaling) && usesRequestsPerSecond(r) && (old == nil || !usesRequestsPerSecond(old)) {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "autoscaling", "requestsPerSecond"),
+			fmt.Sprintf("The autoscaling on the requests per second requires the %s feature gate.", features.CustomMetricsAutoscaling)))
+	}
+
+	return errs
+}
+
+// validateUpdate validates the changes from old that cannot be applied to the existing child resources.
+func (r *DocServer) validateUpdate(old *DocServer) field.ErrorList {
+	var errs field.ErrorList
+
+	if r.Spec.ClassName != old.Spec.ClassName {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "className"), "ClassName is immutable."))
+	}
+
+	errs = append(errs, r.validateStorageUpdate(old)...)
+	return errs
+}
+
+// validateStorageUpdate validates the changes of the PersistentVolumeClaim. The storage class, access modes, volume mode and selector are immutable,
+// and the size cannot be decreased and can be increased only when the storage class allows volume expansion.
+func (r *DocServer) validateStorageUpdate(old *DocServer) field.ErrorList {
+	var errs field.ErrorList
+	path := field.NewPath("spec", "storage")
+
+	// The external storage cannot be switched between the claim and the volume source.
+	if (len(old.Spec.Storage.ExistingClaim) != 0 && r.Spec.Storage.VolumeSource != nil) ||
+		(old.Spec.Storage.VolumeSource != nil && len(r.Spec.Storage.ExistingClaim) != 0) {
+		errs = append(errs, field.Forbidden(path, "Storage cannot be switched between existingClaim and volumeSource."))
+	}
+
+	// The storage not created by the controller can be changed otherwise freely.
+	if r.Spec.Storage.IsExternal() || old.Spec.Storage.IsExternal() {
+		return errs
+	}
+
+	accessModes := func(ds *DocServer) []corev1.PersistentVolumeAccessMode {
+		if len(ds.Spec.Storage.AccessModes) == 0 {
+			return []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+		}
+		return ds.Spec.Storage.AccessModes
+	}
+	if !equality.Semantic.DeepEqual(accessModes(r), accessModes(old)) {
+		errs = append(errs, field.Forbidden(path.Child("accessModes"), "AccessModes is immutable."))
+	}
+	volumeMode := func(ds *DocServer) corev1.PersistentVolumeMode {
+		if ds.Spec.Storage.VolumeMode == nil {
+			return corev1.PersistentVolumeFilesystem
+		}
+		return *ds.Spec.Storage.VolumeMode
+	}
+	if volumeMode(r) != volumeMode(old) {
+		errs = append(errs, field.Forbidden(path.Child("volumeMode"), "VolumeMode is immutable."))
+	}
+	if !equality.Semantic.DeepEqual(r.Spec.Storage.Selector, old.Spec.Storage.Selector) {
+		errs = append(errs, field.Forbidden(path.Child("selector"), "Selector is immutable."))
+	}
+
+	if webhookClient == nil {
+		return errs
+	}
+	ctx := context.Background()
+	class, err := ResolveDocServerClass(ctx, webhookClient, r.Spec.ClassName)
+	if err != nil {
+		docserverlog.Error(err, "unable to resolve DocServerClass, skip storage validation", "name", r.Name)
+		return errs
+	}
+
+	storageClassName := func(ds *DocServer) string {
+		if len(ds.Spec.Storage.StorageClass) != 0 {
+			return ds.Spec.Storage.StorageClass
+		}
+		return class.Spec.Storage.StorageClass
+	}
+	size := func(ds *DocServer) string {
+		if len(ds.Spec.Storage.Size) != 0 {
+			return ds.Spec.Storage.Size
+		}
+		return class.Spec.Storage.Size
+	}
+
+	newClass, oldClass := storageClassName(r), storageClassName(old)
+	if newClass != oldClass {
+		errs = append(errs, field.Forbidden(path.Child("storageClass"), fmt.Sprintf("StorageClass is immutable, it cannot be changed from %q.", oldClass)))
+	}
+
+	newSize, err := resource.ParseQuantity(size(r))
+	if err != nil {
+		// Reported by validate.
+		return errs
+	}
+	oldSize, err := resource.ParseQuantity(size(old))
+	if err != nil {
+		return errs
+	}
+	switch newSize.Cmp(oldSize) {
+	case -1:
+		errs = append(errs, field.Forbidden(path.Child("size"), fmt.Sprintf("Size cannot be decreased from %s.", oldSize.String())))
+	case 1:
+		var sc storagev1.StorageClass
+		err := webhookClient.Get(ctx, client.ObjectKey{Name: oldClass}, &sc)
+		if err != nil && !apierrors.IsNotFound(err) {
+			docserverlog.Error(err, "unable to get StorageClass, skip expansion validation", "name", r.Name)
+			return errs
+		}
+		if err != nil || sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+			errs = append(errs, field.Forbidden(path.Child("size"), fmt.Sprintf("StorageClass %q does not allow volume expansion.", oldClass)))
+		}
+	}
+
+	return errs
+}
+
+func (r *DocServer) toAggregate(errs field.ErrorList) error {
+	if len(errs) > 0 {
+		err := apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "DocServer"}, r.Name, errs)
+		docserverlog.Error(err, "validation error", "name", r.Name)
+		return err
+	}
+
+	return nil
+}
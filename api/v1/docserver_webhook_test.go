@@ -0,0 +1,620 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/component-base/featuregate"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/git-ogawa/docserver/internal/features"
+)
+
+var docServerCount = 0
+
+// newDocServer returns a valid DocServer with a unique name.
+func newDocServer() *DocServer {
+	docServerCount++
+	return &DocServer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("docs-%d", docServerCount),
+			Namespace: "default",
+		},
+		Spec: DocServerSpec{
+			Source: Source{Git: GitSource{URL: "https://github.com/git-ogawa/docserver.git"}},
+		},
+	}
+}
+
+// setFeatureGate sets the feature gate until the end of the spec.
+func setFeatureGate(feature featuregate.Feature, enabled bool) {
+	previous := features.Enabled(feature)
+	Expect(features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{string(feature): enabled})).To(Succeed())
+	DeferCleanup(func() {
+		Expect(features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{string(feature): previous})).To(Succeed())
+	})
+}
+
+var _ = Describe("DocServer webhook", func() {
+	It("accepts the valid DocServer", func() {
+		ds := newDocServer()
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, ds)).To(Succeed())
+	})
+
+	DescribeTable("rejects the invalid DocServer",
+		func(mutate func(*DocServer), field string) {
+			ds := newDocServer()
+			mutate(ds)
+
+			defaulted := ds.DeepCopy()
+			defaulted.Default()
+			err := defaulted.ValidateSpec()
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(field))
+
+			err = k8sClient.Create(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(field))
+		},
+		Entry("too long name for the child resources", func(ds *DocServer) {
+			ds.Name = strings.Repeat("a", 60)
+		}, "metadata.name"),
+		Entry("negative replicas", func(ds *DocServer) {
+			ds.Spec.Replicas = -1
+		}, "spec.replicas"),
+		Entry("invalid server image", func(ds *DocServer) {
+			ds.Spec.Server.Image = "Invalid Image"
+		}, "spec.server.image"),
+		Entry("invalid sync image", func(ds *DocServer) {
+			ds.Spec.Sync.Image = "gitpod:"
+		}, "spec.sync.image"),
+		Entry("invalid storage size", func(ds *DocServer) {
+			ds.Spec.Storage.Size = "3 GB"
+		}, "spec.storage.size"),
+		Entry("both existingClaim and volumeSource", func(ds *DocServer) {
+			ds.Spec.Storage.ExistingClaim = "docs"
+			ds.Spec.Storage.VolumeSource = &corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+		}, "spec.storage.volumeSource"),
+		Entry("multiple replicas with ReadWriteOncePod", func(ds *DocServer) {
+			ds.Spec.Replicas = 2
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}
+		}, "spec.replicas"),
+		Entry("autoscaling with ReadWriteOncePod", func(ds *DocServer) {
+			ds.Spec.Autoscaling = &Autoscaling{MaxReplicas: 3}
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}
+		}, "spec.autoscaling"),
+		Entry("minReplicas greater than maxReplicas", func(ds *DocServer) {
+			ds.Spec.Autoscaling = &Autoscaling{MinReplicas: pointer.Int32(3), MaxReplicas: 2}
+		}, "spec.autoscaling.minReplicas"),
+		Entry("invalid requestsPerSecond target", func(ds *DocServer) {
+			ds.Spec.Autoscaling = &Autoscaling{
+				MaxReplicas:       3,
+				RequestsPerSecond: &RequestsPerSecond{TargetAverageValue: "ten"},
+			}
+		}, "spec.autoscaling.requestsPerSecond.targetAverageValue"),
+		Entry("both minAvailable and maxUnavailable", func(ds *DocServer) {
+			minAvailable, maxUnavailable := intstr.FromInt(1), intstr.FromInt(1)
+			ds.Spec.PodDisruptionBudget = &PodDisruptionBudget{MinAvailable: &minAvailable, MaxUnavailable: &maxUnavailable}
+		}, "spec.podDisruptionBudget.maxUnavailable"),
+		Entry("invalid url", func(ds *DocServer) {
+			ds.Spec.Source.Git.URL = "github.com:"
+		}, "spec.source.git.url"),
+		Entry("both basicAuthSecret and ssh", func(ds *DocServer) {
+			ds.Spec.Source.Git.URL = "git@github.com:git-ogawa/docserver.git"
+			ds.Spec.Source.Git.Auth = GitAuth{BasicAuthSecret: "basic-auth", SSH: &SSHAuth{PrivateKeySecret: "ssh-key"}}
+		}, "spec.source.git.auth.ssh"),
+		Entry("ssh with https url", func(ds *DocServer) {
+			ds.Spec.Source.Git.Auth.SSH = &SSHAuth{PrivateKeySecret: "ssh-key"}
+		}, "spec.source.git.url"),
+		Entry("both inline and configMap known hosts", func(ds *DocServer) {
+			ds.Spec.Source.Git.URL = "git@github.com:git-ogawa/docserver.git"
+			ds.Spec.Source.Git.Auth.SSH = &SSHAuth{
+				PrivateKeySecret: "ssh-key",
+				KnownHosts:       &KnownHosts{Inline: "github.com ssh-ed25519 AAAA", ConfigMap: "known-hosts"},
+			}
+		}, "spec.source.git.auth.ssh.knownHosts"),
+	)
+
+	Context("storage update", func() {
+		BeforeEach(func() {
+			for name, expansion := range map[string]bool{"expandable": true, "fixed": false} {
+				sc := &storagev1.StorageClass{
+					ObjectMeta:           metav1.ObjectMeta{Name: name},
+					Provisioner:          "example.com/docserver",
+					AllowVolumeExpansion: pointer.Bool(expansion),
+				}
+				Expect(client.IgnoreAlreadyExists(k8sClient.Create(ctx, sc))).To(Succeed())
+			}
+		})
+
+		// createDocServer creates the DocServer with the storage class and the size of 1Gi.
+		createDocServer := func(storageClass string) *DocServer {
+			ds := newDocServer()
+			ds.Spec.Storage.StorageClass = storageClass
+			ds.Spec.Storage.Size = "1Gi"
+			Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+			return ds
+		}
+
+		It("allows the expansion when the storage class allows it", func() {
+			ds := createDocServer("expandable")
+			ds.Spec.Storage.Size = "2Gi"
+			Expect(k8sClient.Update(ctx, ds)).To(Succeed())
+		})
+
+		It("rejects the expansion when the storage class does not allow it", func() {
+			ds := createDocServer("fixed")
+			ds.Spec.Storage.Size = "2Gi"
+			err := k8sClient.Update(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("does not allow volume expansion"))
+		})
+
+		It("rejects the shrink", func() {
+			ds := createDocServer("expandable")
+			ds.Spec.Storage.Size = "500Mi"
+			err := k8sClient.Update(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Size cannot be decreased"))
+		})
+
+		It("rejects the change of the storage class", func() {
+			ds := createDocServer("expandable")
+			ds.Spec.Storage.StorageClass = "fixed"
+			err := k8sClient.Update(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.storage.storageClass"))
+		})
+
+		It("rejects the change of the access modes", func() {
+			ds := createDocServer("expandable")
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}
+			err := k8sClient.Update(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.storage.accessModes"))
+		})
+
+		It("rejects the change of the volume mode", func() {
+			ds := createDocServer("expandable")
+			block := corev1.PersistentVolumeBlock
+			ds.Spec.Storage.VolumeMode = &block
+			err := k8sClient.Update(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.storage.volumeMode"))
+		})
+
+		It("allows the volume mode to be set to the default", func() {
+			ds := createDocServer("expandable")
+			filesystem := corev1.PersistentVolumeFilesystem
+			ds.Spec.Storage.VolumeMode = &filesystem
+			Expect(k8sClient.Update(ctx, ds)).To(Succeed())
+		})
+
+		It("rejects the switch from existingClaim to volumeSource", func() {
+			ds := newDocServer()
+			ds.Spec.Storage.ExistingClaim = "docs"
+			Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+			ds.Spec.Storage.ExistingClaim = ""
+			ds.Spec.Storage.VolumeSource = &corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+			err := k8sClient.Update(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cannot be switched between existingClaim and volumeSource"))
+		})
+
+		It("rejects the switch from volumeSource to existingClaim", func() {
+			ds := newDocServer()
+			ds.Spec.Storage.VolumeSource = &corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+			Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+			ds.Spec.Storage.VolumeSource = nil
+			ds.Spec.Storage.ExistingClaim = "docs"
+			err := k8sClient.Update(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cannot be switched between existingClaim and volumeSource"))
+		})
+
+		It("allows any change of the external storage", func() {
+			ds := newDocServer()
+			ds.Spec.Storage.ExistingClaim = "docs"
+			ds.Spec.Storage.Size = "1Gi"
+			Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+			ds.Spec.Storage.Size = "500Mi"
+			ds.Spec.Storage.StorageClass = "fixed"
+			Expect(k8sClient.Update(ctx, ds)).To(Succeed())
+		})
+	})
+
+	Context("feature gates", func() {
+		It("rejects the Snapshot retention policy when SnapshotRetention is disabled", func() {
+			setFeatureGate(features.SnapshotRetention, false)
+
+			ds := newDocServer()
+			ds.Spec.Storage.RetentionPolicy = RetentionPolicySnapshot
+			err := k8sClient.Create(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.storage.retentionPolicy"))
+		})
+
+		It("rejects requestsPerSecond when CustomMetricsAutoscaling is disabled", func() {
+			setFeatureGate(features.CustomMetricsAutoscaling, false)
+
+			ds := newDocServer()
+			ds.Spec.Autoscaling = &Autoscaling{
+				MaxReplicas:       3,
+				RequestsPerSecond: &RequestsPerSecond{TargetAverageValue: "10"},
+			}
+			err := k8sClient.Create(ctx, ds)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.autoscaling.requestsPerSecond"))
+		})
+
+		It("allows the DocServer that already sets the field to be updated", func() {
+			ds := newDocServer()
+			ds.Spec.Storage.RetentionPolicy = RetentionPolicySnapshot
+			Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+			setFeatureGate(features.SnapshotRetention, false)
+
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(ds), ds)).To(Succeed())
+			ds.Spec.Replicas = 2
+			Expect(k8sClient.Update(ctx, ds)).To(Succeed())
+		})
+	})
+})
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*DocServer)
+		fields []string
+	}{
+		{"valid", func(ds *DocServer) {}, nil},
+		{"too long name for the child resources", func(ds *DocServer) {
+			ds.Name = strings.Repeat("a", 60)
+		}, []string{"metadata.name", "metadata.name"}},
+		{"negative replicas", func(ds *DocServer) {
+			ds.Spec.Replicas = -1
+		}, []string{"spec.replicas"}},
+		{"image with registry port, tag and digest", func(ds *DocServer) {
+			ds.Spec.Server.Image = "registry.example.com:5000/org/image:v1@sha256:" + strings.Repeat("a", 64)
+		}, nil},
+		{"invalid server image", func(ds *DocServer) {
+			ds.Spec.Server.Image = "Invalid Image"
+		}, []string{"spec.server.image"}},
+		{"invalid sync image", func(ds *DocServer) {
+			ds.Spec.Sync.Image = "gitpod:"
+		}, []string{"spec.sync.image"}},
+		{"invalid storage size", func(ds *DocServer) {
+			ds.Spec.Storage.Size = "3 GB"
+		}, []string{"spec.storage.size"}},
+		{"both existingClaim and volumeSource", func(ds *DocServer) {
+			ds.Spec.Storage.ExistingClaim = "docs"
+			ds.Spec.Storage.VolumeSource = &corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+		}, []string{"spec.storage.volumeSource"}},
+		{"single replica with ReadWriteOncePod", func(ds *DocServer) {
+			ds.Spec.Replicas = 1
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}
+		}, nil},
+		{"multiple replicas with ReadWriteOncePod", func(ds *DocServer) {
+			ds.Spec.Replicas = 2
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}
+		}, []string{"spec.replicas"}},
+		{"autoscaling with ReadWriteOncePod", func(ds *DocServer) {
+			ds.Spec.Autoscaling = &Autoscaling{MaxReplicas: 3}
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}
+		}, []string{"spec.autoscaling"}},
+		{"minReplicas greater than maxReplicas", func(ds *DocServer) {
+			ds.Spec.Autoscaling = &Autoscaling{MinReplicas: pointer.Int32(3), MaxReplicas: 2}
+		}, []string{"spec.autoscaling.minReplicas"}},
+		{"invalid requestsPerSecond target", func(ds *DocServer) {
+			ds.Spec.Autoscaling = &Autoscaling{
+				MaxReplicas:       3,
+				RequestsPerSecond: &RequestsPerSecond{TargetAverageValue: "ten"},
+			}
+		}, []string{"spec.autoscaling.requestsPerSecond.targetAverageValue"}},
+		{"both minAvailable and maxUnavailable", func(ds *DocServer) {
+			ds.Spec.PodDisruptionBudget = &PodDisruptionBudget{
+				MinAvailable:   &intstr.IntOrString{Type: intstr.Int, IntVal: 1},
+				MaxUnavailable: &intstr.IntOrString{Type: intstr.Int, IntVal: 1},
+			}
+		}, []string{"spec.podDisruptionBudget.maxUnavailable"}},
+		{"invalid url", func(ds *DocServer) {
+			ds.Spec.Source.Git.URL = "github.com/git-ogawa/docserver"
+		}, []string{"spec.source.git.url"}},
+		{"zero depth", func(ds *DocServer) {
+			ds.Spec.Source.Git.Depth = 0
+		}, []string{"spec.source.git.depth"}},
+		{"both basicAuthSecret and ssh", func(ds *DocServer) {
+			ds.Spec.Source.Git.URL = "git@github.com:git-ogawa/docserver.git"
+			ds.Spec.Source.Git.Auth.BasicAuthSecret = "basic-auth"
+			ds.Spec.Source.Git.Auth.SSH = &SSHAuth{PrivateKeySecret: "ssh-key"}
+		}, []string{"spec.source.git.auth.ssh"}},
+		{"ssh with https url", func(ds *DocServer) {
+			ds.Spec.Source.Git.Auth.SSH = &SSHAuth{PrivateKeySecret: "ssh-key"}
+		}, []string{"spec.source.git.url"}},
+		{"both inline and configMap known hosts", func(ds *DocServer) {
+			ds.Spec.Source.Git.URL = "git@github.com:git-ogawa/docserver.git"
+			ds.Spec.Source.Git.Auth.SSH = &SSHAuth{
+				PrivateKeySecret: "ssh-key",
+				KnownHosts:       &KnownHosts{Inline: "github.com ssh-ed25519 AAAA", ConfigMap: "known-hosts"},
+			}
+		}, []string{"spec.source.git.auth.ssh.knownHosts"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newDocServer()
+			ds.Default()
+			tt.mutate(ds)
+
+			var actual []string
+			for _, err := range ds.validate() {
+				actual = append(actual, err.Field)
+			}
+			if strings.Join(actual, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("validate() rejects %v, want %v", actual, tt.fields)
+			}
+
+			err := ds.ValidateCreate()
+			if len(tt.fields) == 0 && err != nil {
+				t.Errorf("ValidateCreate() = %v, want nil", err)
+			}
+			if len(tt.fields) != 0 && !apierrors.IsInvalid(err) {
+				t.Errorf("ValidateCreate() = %v, want Invalid", err)
+			}
+		})
+	}
+}
+
+// setWebhookClient sets webhookClient to the fake client with objs until the end of the test.
+func setWebhookClient(t *testing.T, objs ...client.Object) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := storagev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	previous := webhookClient
+	webhookClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	t.Cleanup(func() { webhookClient = previous })
+}
+
+func TestValidateStorageUpdate(t *testing.T) {
+	block := corev1.PersistentVolumeBlock
+	filesystem := corev1.PersistentVolumeFilesystem
+	emptyDir := &corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+
+	tests := []struct {
+		name   string
+		old    func(*DocServer)
+		mutate func(*DocServer)
+		fields []string
+	}{
+		{"no change", func(ds *DocServer) {}, func(ds *DocServer) {}, nil},
+		{"className", func(ds *DocServer) {}, func(ds *DocServer) {
+			ds.Spec.ClassName = "other"
+		}, []string{"spec.className"}},
+		{"accessModes", func(ds *DocServer) {}, func(ds *DocServer) {
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}
+		}, []string{"spec.storage.accessModes"}},
+		{"accessModes set to the default", func(ds *DocServer) {}, func(ds *DocServer) {
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+		}, nil},
+		{"volumeMode", func(ds *DocServer) {}, func(ds *DocServer) {
+			ds.Spec.Storage.VolumeMode = &block
+		}, []string{"spec.storage.volumeMode"}},
+		{"volumeMode set to the default", func(ds *DocServer) {}, func(ds *DocServer) {
+			ds.Spec.Storage.VolumeMode = &filesystem
+		}, nil},
+		{"selector", func(ds *DocServer) {}, func(ds *DocServer) {
+			ds.Spec.Storage.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "docs"}}
+		}, []string{"spec.storage.selector"}},
+		{"existingClaim to volumeSource", func(ds *DocServer) {
+			ds.Spec.Storage.ExistingClaim = "docs"
+		}, func(ds *DocServer) {
+			ds.Spec.Storage.ExistingClaim = ""
+			ds.Spec.Storage.VolumeSource = emptyDir
+		}, []string{"spec.storage"}},
+		{"volumeSource to existingClaim", func(ds *DocServer) {
+			ds.Spec.Storage.VolumeSource = emptyDir
+		}, func(ds *DocServer) {
+			ds.Spec.Storage.VolumeSource = nil
+			ds.Spec.Storage.ExistingClaim = "docs"
+		}, []string{"spec.storage"}},
+		{"external storage", func(ds *DocServer) {
+			ds.Spec.Storage.ExistingClaim = "docs"
+		}, func(ds *DocServer) {
+			ds.Spec.Storage.ExistingClaim = "other"
+			ds.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+			ds.Spec.Storage.VolumeMode = &block
+		}, nil},
+	}
+
+	// The rules that do not read the cluster are applied without the client.
+	previous := webhookClient
+	webhookClient = nil
+	t.Cleanup(func() { webhookClient = previous })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newDocServer()
+			old.Default()
+			tt.old(old)
+			ds := old.DeepCopy()
+			tt.mutate(ds)
+
+			var actual []string
+			for _, err := range ds.validateUpdate(old) {
+				actual = append(actual, err.Field)
+			}
+			if strings.Join(actual, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("validateUpdate() rejects %v, want %v", actual, tt.fields)
+			}
+		})
+	}
+}
+
+func TestValidateStorageUpdateSize(t *testing.T) {
+	setWebhookClient(t,
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "expandable"}, AllowVolumeExpansion: pointer.Bool(true)},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fixed"}, AllowVolumeExpansion: pointer.Bool(false)},
+		&DocServerClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{DefaultClassAnnotation: "true"}},
+			Spec:       DocServerClassSpec{Storage: ClassStorage{StorageClass: "fixed", Size: "1Gi"}},
+		},
+	)
+
+	tests := []struct {
+		name         string
+		storageClass string
+		oldSize      string
+		size         string
+		expected     string
+	}{
+		{"same size", "fixed", "1Gi", "1024Mi", ""},
+		{"expansion allowed by the storage class", "expandable", "1Gi", "2Gi", ""},
+		{"expansion not allowed by the storage class", "fixed", "1Gi", "2Gi", `StorageClass "fixed" does not allow volume expansion.`},
+		{"expansion with the storage class not found", "missing", "1Gi", "2Gi", `StorageClass "missing" does not allow volume expansion.`},
+		{"shrink", "expandable", "1Gi", "500Mi", "Size cannot be decreased from 1Gi."},
+		{"expansion of the class defaults", "", "", "2Gi", `StorageClass "fixed" does not allow volume expansion.`},
+		{"shrink of the class defaults", "", "", "500Mi", "Size cannot be decreased from 1Gi."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newDocServer()
+			old.Default()
+			old.Spec.Storage.StorageClass = tt.storageClass
+			old.Spec.Storage.Size = tt.oldSize
+			ds := old.DeepCopy()
+			ds.Spec.Storage.Size = tt.size
+
+			errs := ds.validateStorageUpdate(old)
+			switch {
+			case len(tt.expected) == 0 && len(errs) != 0:
+				t.Errorf("validateStorageUpdate() = %v, want none", errs)
+			case len(tt.expected) != 0 && (len(errs) != 1 || errs[0].Field != "spec.storage.size" || errs[0].Detail != tt.expected):
+				t.Errorf("validateStorageUpdate() = %v, want spec.storage.size: %s", errs, tt.expected)
+			}
+		})
+	}
+
+	old := newDocServer()
+	old.Default()
+	old.Spec.Storage.StorageClass = "expandable"
+	ds := old.DeepCopy()
+	ds.Spec.Storage.StorageClass = "fixed"
+	if errs := ds.validateStorageUpdate(old); len(errs) != 1 || errs[0].Field != "spec.storage.storageClass" {
+		t.Errorf("validateStorageUpdate() of the storage class change = %v, want spec.storage.storageClass", errs)
+	}
+}
+
+func TestValidateUpdateDeleting(t *testing.T) {
+	old := newDocServer()
+	old.Default()
+	ds := old.DeepCopy()
+	ds.Spec.Replicas = -1
+	ds.Spec.ClassName = "other"
+	if err := ds.ValidateUpdate(old); !apierrors.IsInvalid(err) {
+		t.Errorf("ValidateUpdate() = %v, want Invalid", err)
+	}
+
+	now := metav1.Now()
+	ds.DeletionTimestamp = &now
+	if err := ds.ValidateUpdate(old); err != nil {
+		t.Errorf("ValidateUpdate() of the deleting DocServer = %v, want nil", err)
+	}
+}
+
+func TestValidateFeatureGates(t *testing.T) {
+	snapshot := func(ds *DocServer) { ds.Spec.Storage.RetentionPolicy = RetentionPolicySnapshot }
+	requestsPerSecond := func(ds *DocServer) {
+		ds.Spec.Autoscaling = &Autoscaling{MaxReplicas: 3, RequestsPerSecond: &RequestsPerSecond{TargetAverageValue: "10"}}
+	}
+	none := func(ds *DocServer) {}
+
+	tests := []struct {
+		name    string
+		feature featuregate.Feature
+		enabled bool
+		old     func(*DocServer)
+		mutate  func(*DocServer)
+		field   string
+	}{
+		{"Snapshot with SnapshotRetention", features.SnapshotRetention, true, nil, snapshot, ""},
+		{"Snapshot without SnapshotRetention", features.SnapshotRetention, false, nil, snapshot, "spec.storage.retentionPolicy"},
+		{"Snapshot set by the update without SnapshotRetention", features.SnapshotRetention, false, none, snapshot, "spec.storage.retentionPolicy"},
+		{"Snapshot already set without SnapshotRetention", features.SnapshotRetention, false, snapshot, snapshot, ""},
+		{"requestsPerSecond with CustomMetricsAutoscaling", features.CustomMetricsAutoscaling, true, nil, requestsPerSecond, ""},
+		{"requestsPerSecond without CustomMetricsAutoscaling", features.CustomMetricsAutoscaling, false, nil, requestsPerSecond, "spec.autoscaling.requestsPerSecond"},
+		{"requestsPerSecond set by the update without CustomMetricsAutoscaling", features.CustomMetricsAutoscaling, false, none, requestsPerSecond, "spec.autoscaling.requestsPerSecond"},
+		{"requestsPerSecond already set without CustomMetricsAutoscaling", features.CustomMetricsAutoscaling, false, requestsPerSecond, requestsPerSecond, ""},
+		{"neither without the features", features.SnapshotRetention, false, nil, none, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := features.Enabled(tt.feature)
+			if err := features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{string(tt.feature): tt.enabled}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				if err := features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{string(tt.feature): previous}); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			var old *DocServer
+			if tt.old != nil {
+				old = newDocServer()
+				old.Default()
+				tt.old(old)
+			}
+			ds := newDocServer()
+			ds.Default()
+			tt.mutate(ds)
+
+			errs := ds.validateFeatureGates(old)
+			switch {
+			case len(tt.field) == 0 && len(errs) != 0:
+				t.Errorf("validateFeatureGates() = %v, want none", errs)
+			case len(tt.field) != 0 && (len(errs) != 1 || errs[0].Field != tt.field || errs[0].Type != field.ErrorTypeForbidden):
+				t.Errorf("validateFeatureGates() = %v, want %s forbidden", errs, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ResolveDocServerClass returns the DocServerClass named className, whose empty fields are filled with the built-in defaults.
+// The default class is used if className is empty, and the built-in defaults are returned if there is no default class.
+func ResolveDocServerClass(ctx context.Context, c client.Reader, className string) (*DocServerClass, error) {
+	return ResolveDocServerClassWithDefaults(ctx, c, className, DocServerClassSpec{})
+}
+
+// ResolveDocServerClassWithDefaults is like ResolveDocServerClass, but the images set in defaults
+// are used instead of the built-in defaults.
+func ResolveDocServerClassWithDefaults(ctx context.Context, c client.Reader, className string, defaults DocServerClassSpec) (*DocServerClass, error) {
+	logger := logf.FromContext(ctx)
+
+	class := &DocServerClass{}
+	if len(className) != 0 {
+		err := c.Get(ctx, client.ObjectKey{Name: className}, class)
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("DocServerClass %q not found", className)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var classList DocServerClassList
+		err := c.List(ctx, &classList)
+		if err != nil {
+			return nil, err
+		}
+		var defaultClasses []DocServerClass
+		for _, item := range classList.Items {
+			if item.IsDefault() {
+				defaultClasses = append(defaultClasses, item)
+			}
+		}
+		if len(defaultClasses) > 1 {
+			sort.Slice(defaultClasses, func(i, j int) bool { return defaultClasses[i].Name < defaultClasses[j].Name })
+			logger.Info("multiple default DocServerClasses found, use the first one", "class", defaultClasses[0].Name)
+		}
+		if len(defaultClasses) != 0 {
+			class = &defaultClasses[0]
+		}
+	}
+
+	class = class.DeepCopy()
+	if len(class.Spec.Image) == 0 {
+		class.Spec.Image = defaults.Image
+	}
+	if len(class.Spec.GitpodImage) == 0 {
+		class.Spec.GitpodImage = defaults.GitpodImage
+	}
+	if len(class.Spec.Image) == 0 {
+		class.Spec.Image = DefaultImage
+	}
+	if len(class.Spec.GitpodImage) == 0 {
+		class.Spec.GitpodImage = DefaultGitpodImage
+	}
+	if len(class.Spec.Storage.Size) == 0 {
+		class.Spec.Storage.Size = DefaultStorageSize
+	}
+	if len(class.Spec.Storage.StorageClass) == 0 {
+		class.Spec.Storage.StorageClass = DefaultStorageClass
+	}
+	if class.Spec.BackoffLimit == nil {
+		backoffLimit := DefaultBackoffLimit
+		class.Spec.BackoffLimit = &backoffLimit
+	}
+	return class, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var docserverclasslog = logf.Log.WithName("docserverclass-resource")
+
+func (r *DocServerClass) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-update-git-ogawa-github-io-v1-docserverclass,mutating=false,failurePolicy=fail,sideEffects=None,groups=update.git-ogawa.github.io,resources=docserverclasses,verbs=create;update,versions=v1,name=vdocserverclass.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &DocServerClass{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *DocServerClass) ValidateCreate() error {
+	docserverclasslog.Info("validate create", "name", r.Name)
+
+	return r.ValidateSpec()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *DocServerClass) ValidateUpdate(old runtime.Object) error {
+	docserverclasslog.Info("validate update", "name", r.Name)
+
+	return r.ValidateSpec()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *DocServerClass) ValidateDelete() error {
+	docserverclasslog.Info("validate delete", "name", r.Name)
+
+	return nil
+}
+
+// ValidateSpec validates the DocServerClass as the webhook does.
+// The controller uses it to reject the classes created while the webhook was disabled.
+func (r *DocServerClass) ValidateSpec() error {
+	var errs field.ErrorList
+
+	imagePaths := []struct {
+		path  *field.Path
+		image string
+	}{
+		{field.NewPath("spec", "image"), r.Spec.Image},
+		{field.NewPath("spec", "gitpodImage"), r.Spec.GitpodImage},
+	}
+	for _, p := range imagePaths {
+		if len(p.image) != 0 && !imageReferenceRegexp.MatchString(p.image) {
+			errs = append(errs, field.Invalid(p.path, p.image, "Image must be a valid image reference."))
+		}
+	}
+
+	if len(r.Spec.Storage.Size) != 0 {
+		if _, err := resource.ParseQuantity(r.Spec.Storage.Size); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "storage", "size"), r.Spec.Storage.Size, err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "DocServerClass"}, r.Name, errs)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("DocServerClass webhook", func() {
+	It("accepts the valid class", func() {
+		class := &DocServerClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "valid"},
+			Spec: DocServerClassSpec{
+				Image:       "registry.example.com:5000/mkdocs-material:9.1",
+				GitpodImage: "docogawa/gitpod:latest",
+				Storage:     ClassStorage{Size: "5Gi"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, class)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, class)).To(Succeed())
+	})
+
+	DescribeTable("rejects the invalid class",
+		func(spec DocServerClassSpec, field string) {
+			class := &DocServerClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+				Spec:       spec,
+			}
+			err := class.ValidateSpec()
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(field))
+
+			err = k8sClient.Create(ctx, class)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(field))
+		},
+		Entry("invalid image", DocServerClassSpec{Image: "Invalid Image"}, "spec.image"),
+		Entry("invalid gitpod image", DocServerClassSpec{GitpodImage: "gitpod:"}, "spec.gitpodImage"),
+		Entry("invalid storage size", DocServerClassSpec{Storage: ClassStorage{Size: "3 GB"}}, "spec.storage.size"),
+	)
+})
@@ -0,0 +1,209 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v1 "github.com/git-ogawa/docserver/api/v1"
+)
+
+// CommitAnnotation is the annotation that shows the commit synced by the gitpod Job,
+// which is status.commit in v1.
+const CommitAnnotation = "docserver.git-ogawa.github.io/commit"
+
+var _ conversion.Convertible = &DocServer{}
+
+// ConvertTo converts this DocServer to the hub version (v1).
+func (src *DocServer) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1.DocServer)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	if commit, ok := dst.Annotations[CommitAnnotation]; ok {
+		dst.Status.Commit = commit
+		delete(dst.Annotations, CommitAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	target := src.Spec.Target
+	git := v1.GitSource{
+		URL:    target.Url,
+		Branch: target.Branch,
+		Depth:  target.Depth,
+		LFS:    target.LFS,
+		Auth: v1.GitAuth{
+			BasicAuthSecret: target.BasicAuthSecret,
+		},
+		TLS: v1.GitTLS{
+			Verify:   target.SSLVerify,
+			CASecret: target.TLSSecret,
+		},
+	}
+	if target.Submodules != nil {
+		git.Submodules = &v1.Submodules{
+			Recursive: target.Submodules.Recursive,
+			Shallow:   target.Submodules.Shallow,
+		}
+	}
+	if target.SSHSecret != nil {
+		git.Auth.SSH = &v1.SSHAuth{
+			ConfigMap:             target.SSHSecret.Config,
+			PrivateKeySecret:      target.SSHSecret.PrivateKey,
+			StrictHostKeyChecking: target.SSHSecret.StrictHostKeyChecking,
+		}
+		if target.SSHSecret.KnownHosts != nil {
+			git.Auth.SSH.KnownHosts = &v1.KnownHosts{
+				Inline:    target.SSHSecret.KnownHosts.Inline,
+				ConfigMap: target.SSHSecret.KnownHosts.ConfigMap,
+			}
+		}
+	}
+
+	dst.Spec = v1.DocServerSpec{
+		ClassName: src.Spec.ClassName,
+		Source:    v1.Source{Git: git},
+		Replicas:  src.Spec.Replicas,
+		Server:    v1.Server{Image: src.Spec.Image},
+		Sync:      v1.Sync{Image: src.Spec.Gitpod.Image},
+		Storage: v1.Storage{
+			Size:               src.Spec.Storage.Size,
+			StorageClass:       src.Spec.Storage.StorageClass,
+			BlockOwnerDeletion: src.Spec.Storage.BlockOwnerDeletion,
+			RetentionPolicy:    v1.RetentionPolicy(src.Spec.Storage.RetentionPolicy),
+			SnapshotClassName:  src.Spec.Storage.SnapshotClassName,
+			AccessModes:        src.Spec.Storage.AccessModes,
+			VolumeMode:         src.Spec.Storage.VolumeMode,
+			Labels:             src.Spec.Storage.Labels,
+			Annotations:        src.Spec.Storage.Annotations,
+			Selector:           src.Spec.Storage.Selector,
+			ExistingClaim:      src.Spec.Storage.ExistingClaim,
+			VolumeSource:       src.Spec.Storage.VolumeSource,
+		},
+		Autoscaling:         convertAutoscalingTo(src.Spec.Autoscaling),
+		PodDisruptionBudget: (*v1.PodDisruptionBudget)(src.Spec.PodDisruptionBudget),
+		ImagePullSecrets:    src.Spec.ImagePullSecrets,
+		ServiceAccountName:  src.Spec.ServiceAccountName,
+	}
+
+	dst.Status.Phase = v1.DocServerPhase(src.Status)
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v1) to this DocServer.
+// The conditions and observedGeneration in the status are dropped since v1beta1 has no place for them.
+func (dst *DocServer) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1.DocServer)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	if len(src.Status.Commit) != 0 {
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[CommitAnnotation] = src.Status.Commit
+	}
+
+	git := src.Spec.Source.Git
+	target := Target{
+		Url:             git.URL,
+		Branch:          git.Branch,
+		SSLVerify:       git.TLS.Verify,
+		BasicAuthSecret: git.Auth.BasicAuthSecret,
+		TLSSecret:       git.TLS.CASecret,
+		Depth:           git.Depth,
+		LFS:             git.LFS,
+	}
+	if git.Submodules != nil {
+		target.Submodules = &Submodules{
+			Recursive: git.Submodules.Recursive,
+			Shallow:   git.Submodules.Shallow,
+		}
+	}
+	if git.Auth.SSH != nil {
+		target.SSHSecret = &SSHSecret{
+			Config:                git.Auth.SSH.ConfigMap,
+			PrivateKey:            git.Auth.SSH.PrivateKeySecret,
+			StrictHostKeyChecking: git.Auth.SSH.StrictHostKeyChecking,
+		}
+		if git.Auth.SSH.KnownHosts != nil {
+			target.SSHSecret.KnownHosts = &KnownHosts{
+				Inline:    git.Auth.SSH.KnownHosts.Inline,
+				ConfigMap: git.Auth.SSH.KnownHosts.ConfigMap,
+			}
+		}
+	}
+
+	dst.Spec = DocServerSpec{
+		ClassName: src.Spec.ClassName,
+		Target:    target,
+		Replicas:  src.Spec.Replicas,
+		Image:     src.Spec.Server.Image,
+		Storage: Storage{
+			Size:               src.Spec.Storage.Size,
+			StorageClass:       src.Spec.Storage.StorageClass,
+			BlockOwnerDeletion: src.Spec.Storage.BlockOwnerDeletion,
+			RetentionPolicy:    RetentionPolicy(src.Spec.Storage.RetentionPolicy),
+			SnapshotClassName:  src.Spec.Storage.SnapshotClassName,
+			AccessModes:        src.Spec.Storage.AccessModes,
+			VolumeMode:         src.Spec.Storage.VolumeMode,
+			Labels:             src.Spec.Storage.Labels,
+			Annotations:        src.Spec.Storage.Annotations,
+			Selector:           src.Spec.Storage.Selector,
+			ExistingClaim:      src.Spec.Storage.ExistingClaim,
+			VolumeSource:       src.Spec.Storage.VolumeSource,
+		},
+		Gitpod:              Gitpod{Image: src.Spec.Sync.Image},
+		Autoscaling:         convertAutoscalingFrom(src.Spec.Autoscaling),
+		PodDisruptionBudget: (*PodDisruptionBudget)(src.Spec.PodDisruptionBudget),
+		ImagePullSecrets:    src.Spec.ImagePullSecrets,
+		ServiceAccountName:  src.Spec.ServiceAccountName,
+	}
+
+	dst.Status = DocServerStatus(src.Status.Phase)
+	return nil
+}
+
+func convertAutoscalingTo(src *Autoscaling) *v1.Autoscaling {
+	if src == nil {
+		return nil
+	}
+	dst := &v1.Autoscaling{
+		MinReplicas:                    src.MinReplicas,
+		MaxReplicas:                    src.MaxReplicas,
+		TargetCPUUtilizationPercentage: src.TargetCPUUtilizationPercentage,
+	}
+	if src.RequestsPerSecond != nil {
+		dst.RequestsPerSecond = (*v1.RequestsPerSecond)(src.RequestsPerSecond)
+	}
+	return dst
+}
+
+func convertAutoscalingFrom(src *v1.Autoscaling) *Autoscaling {
+	if src == nil {
+		return nil
+	}
+	dst := &Autoscaling{
+		MinReplicas:                    src.MinReplicas,
+		MaxReplicas:                    src.MaxReplicas,
+		TargetCPUUtilizationPercentage: src.TargetCPUUtilizationPercentage,
+	}
+	if src.RequestsPerSecond != nil {
+		dst.RequestsPerSecond = (*RequestsPerSecond)(src.RequestsPerSecond)
+	}
+	return dst
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	v1 "github.com/git-ogawa/docserver/api/v1"
+)
+
+func TestDocServerRoundTrip(t *testing.T) {
+	minAvailable := intstr.FromString("50%")
+	block := corev1.PersistentVolumeBlock
+	tests := []struct {
+		name string
+		ds   DocServer
+	}{
+		{
+			name: "minimal",
+			ds: DocServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "docs", Namespace: "default"},
+				Spec: DocServerSpec{
+					Target: Target{Url: "https://github.com/git-ogawa/docserver"},
+				},
+			},
+		},
+		{
+			name: "autoscaling",
+			ds: DocServer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "docs",
+					Namespace:   "default",
+					Annotations: map[string]string{CommitAnnotation: "0123456789abcdef"},
+				},
+				Spec: DocServerSpec{
+					Target:   Target{Url: "https://github.com/git-ogawa/docserver", Branch: "develop"},
+					Replicas: 2,
+					Autoscaling: &Autoscaling{
+						MinReplicas:                    pointer.Int32(2),
+						MaxReplicas:                    5,
+						TargetCPUUtilizationPercentage: pointer.Int32(60),
+						RequestsPerSecond: &RequestsPerSecond{
+							MetricName:         "nginx_http_requests_per_second",
+							TargetAverageValue: "10",
+						},
+					},
+				},
+				Status: DocServerStatus(v1.DocServerHealthy),
+			},
+		},
+		{
+			name: "podDisruptionBudget",
+			ds: DocServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "docs", Namespace: "default"},
+				Spec: DocServerSpec{
+					Target:   Target{Url: "https://github.com/git-ogawa/docserver"},
+					Replicas: 3,
+					PodDisruptionBudget: &PodDisruptionBudget{
+						Enabled:      pointer.Bool(true),
+						MinAvailable: &minAvailable,
+					},
+				},
+			},
+		},
+		{
+			name: "storage",
+			ds: DocServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "docs", Namespace: "default"},
+				Spec: DocServerSpec{
+					Target: Target{Url: "https://github.com/git-ogawa/docserver"},
+					Storage: Storage{
+						Size:        "2Gi",
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						VolumeMode:  &block,
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hub v1.DocServer
+			if err := tt.ds.DeepCopy().ConvertTo(&hub); err != nil {
+				t.Fatalf("ConvertTo: %v", err)
+			}
+			var got DocServer
+			if err := got.ConvertFrom(&hub); err != nil {
+				t.Fatalf("ConvertFrom: %v", err)
+			}
+			if !equality.Semantic.DeepEqual(tt.ds, got) {
+				t.Errorf("round trip changed the DocServer:\n%s", diff.ObjectReflectDiff(tt.ds, got))
+			}
+		})
+	}
+}
+
+func TestConvertToAutoscalingAndPodDisruptionBudget(t *testing.T) {
+	maxUnavailable := intstr.FromInt(1)
+	src := DocServer{
+		Spec: DocServerSpec{
+			Autoscaling:         &Autoscaling{MaxReplicas: 3},
+			PodDisruptionBudget: &PodDisruptionBudget{MaxUnavailable: &maxUnavailable},
+		},
+	}
+	var dst v1.DocServer
+	if err := src.ConvertTo(&dst); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if dst.Spec.Autoscaling == nil || dst.Spec.Autoscaling.MaxReplicas != 3 {
+		t.Errorf("autoscaling is not converted: %+v", dst.Spec.Autoscaling)
+	}
+	if dst.Spec.PodDisruptionBudget == nil || dst.Spec.PodDisruptionBudget.MaxUnavailable.IntValue() != 1 {
+		t.Errorf("podDisruptionBudget is not converted: %+v", dst.Spec.PodDisruptionBudget)
+	}
+}
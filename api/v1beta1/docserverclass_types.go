@@ -0,0 +1,145 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DocServerClassSpec defines the default properties of the DocServers that belong to the class.
+type DocServerClassSpec struct {
+	// Image is the name:tag of the image used by the docserver container.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// GitpodImage is the name:tag of the image used by the gitpod container.
+	// +optional
+	GitpodImage string `json:"gitpodImage,omitempty"`
+
+	// Storage is the properties of persistenVolumeClaim.
+	// +optional
+	Storage ClassStorage `json:"storage,omitempty"`
+
+	// BackoffLimit is the number of retries of the gitpod Job.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+
+	// PodTemplate is the template applied to the docserver pods.
+	// +optional
+	PodTemplate *PodTemplate `json:"podTemplate,omitempty"`
+
+	// GitpodPodTemplate is the template applied to the gitpod pods.
+	// +optional
+	GitpodPodTemplate *PodTemplate `json:"gitpodPodTemplate,omitempty"`
+
+	// Ingress is the properties of the Ingress created for the DocServers. The Ingress is not created if not set.
+	// +optional
+	Ingress *IngressTemplate `json:"ingress,omitempty"`
+}
+
+// ClassStorage defines the default properties of persistenVolumeClaim.
+type ClassStorage struct {
+	// Size is the volume capacity requested by persistenVolumeClaim.
+	// +optional
+	Size string `json:"size,omitempty"`
+
+	// StorageClass is StorageClassName of persistenVolumeClaim.
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// PodTemplate defines the properties applied to the pods.
+type PodTemplate struct {
+	// Labels is the labels added to the pods.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations is the annotations added to the pods.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// NodeSelector is the nodeSelector of the pods.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations is the tolerations of the pods.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity is the affinity of the pods.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// PriorityClassName is the priorityClassName of the pods.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// SecurityContext is the securityContext of the pods.
+	// +optional
+	SecurityContext *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+
+	// Resources is the resources of the container.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// IngressTemplate defines the properties of the Ingress created for the DocServers.
+type IngressTemplate struct {
+	// Domain is the domain of the host. The host of the Ingress is <name>-<namespace>.<domain>.
+	// +kubebuilder:validation:Required
+	Domain string `json:"domain"`
+
+	// IngressClassName is the ingressClassName of the Ingress.
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// Annotations is the annotations added to the Ingress.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// TLSSecret is the name of secret in the namespace of the DocServer used for TLS termination.
+	// +optional
+	TLSSecret string `json:"tlsSecret,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="DEFAULT",type="string",JSONPath=".metadata.annotations.docserver\\.git-ogawa\\.github\\.io/is-default-class"
+//+kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// DocServerClass is the Schema for the docserverclasses API
+type DocServerClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DocServerClassSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DocServerClassList contains a list of DocServerClass
+type DocServerClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DocServerClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DocServerClass{}, &DocServerClassList{})
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/git-ogawa/docserver/internal/gitpod"
+	"github.com/git-ogawa/docserver/internal/tracing"
+)
+
+var setupLog = ctrl.Log.WithName("gitpod")
+
+func main() {
+	var retries int
+	var retryInterval time.Duration
+	var terminationMessagePath string
+	flag.IntVar(&retries, "retries", 5, "The number of attempts to sync the sources.")
+	flag.DurationVar(&retryInterval, "retry-interval", 2*time.Second,
+		"The initial interval between the attempts, which is doubled on each failure.")
+	flag.StringVar(&terminationMessagePath, "termination-message-path", "/dev/termination-log",
+		"The file where the synced commit and its committer date are written so that the controller can read it from the container status.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	ctx := ctrl.SetupSignalHandler()
+
+	shutdown, err := tracing.Setup(ctx, tracing.OptionsFromEnv("docserver-gitpod"))
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	// The sync is traced as a child of the span of the controller creating the Job.
+	ctx, span := otel.Tracer("github.com/git-ogawa/docserver/cmd/gitpod").Start(tracing.ExtractEnv(ctx), "gitpod")
+	// exit flushes the spans before exiting since os.Exit does not run the deferred functions.
+	exit := func(code int) {
+		span.End()
+		if err := shutdown(context.Background()); err != nil {
+			setupLog.Error(err, "unable to shut down tracing")
+		}
+		os.Exit(code)
+	}
+
+	config, err := gitpod.ConfigFromEnv()
+	if err != nil {
+		setupLog.Error(err, "invalid configuration")
+		exit(1)
+	}
+
+	syncer := &gitpod.Syncer{
+		Config: config,
+		Log:    setupLog,
+	}
+	if err := syncer.Prepare(ctx); err != nil {
+		setupLog.Error(err, "unable to prepare sync")
+		exit(1)
+	}
+
+	var commit gitpod.Commit
+	var syncErr error
+	attempt := 0
+	backoff := wait.Backoff{
+		Duration: retryInterval,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    retries,
+	}
+	err = wait.ExponentialBackoffWithContext(ctx, backoff, func() (bool, error) {
+		attempt++
+		commit, syncErr = syncer.Sync(ctx)
+		if syncErr != nil {
+			setupLog.Error(syncErr, "sync failed", "attempt", attempt, "retries", retries)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		if syncErr != nil {
+			err = syncErr
+		}
+		setupLog.Error(err, "unable to sync sources", "attempts", attempt)
+		exit(1)
+	}
+
+	if err := os.WriteFile(terminationMessagePath, []byte(commit.TerminationMessage()), 0644); err != nil {
+		setupLog.Error(err, "unable to write termination message", "path", terminationMessagePath)
+	}
+	setupLog.Info("successfully completed", "commit", commit.SHA, "committed", commit.Time)
+	exit(0)
+}
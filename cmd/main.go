@@ -17,22 +17,38 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"path/filepath"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	cliflag "k8s.io/component-base/cli/flag"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
 	updatev1beta1 "github.com/git-ogawa/docserver/api/v1beta1"
+	"github.com/git-ogawa/docserver/internal/certrotator"
+	"github.com/git-ogawa/docserver/internal/config"
 	"github.com/git-ogawa/docserver/internal/controller"
+	"github.com/git-ogawa/docserver/internal/features"
+	"github.com/git-ogawa/docserver/internal/health"
+	"github.com/git-ogawa/docserver/internal/migration"
+	"github.com/git-ogawa/docserver/internal/tracing"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,33 +61,114 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(updatev1beta1.AddToScheme(scheme))
+	utilruntime.Must(updatev1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
+	var configFile string
+	var tracingOpts tracing.Options
+	cfg := config.Default()
+	flag.StringVar(&configFile, "config", "",
+		"The path of the ControllerConfig file. The flags set explicitly take precedence over the values in the file.")
+	flag.StringVar(&cfg.Metrics.BindAddress, "metrics-bind-address", cfg.Metrics.BindAddress, "The address the metric endpoint binds to.")
+	flag.StringVar(&cfg.Health.HealthProbeBindAddress, "health-probe-bind-address", cfg.Health.HealthProbeBindAddress,
+		"The address the probe endpoint binds to.")
+	flag.BoolVar(&cfg.LeaderElection.LeaderElect, "leader-elect", cfg.LeaderElection.LeaderElect,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
-	opts := zap.Options{
-		Development: true,
-	}
+	flag.StringVar(&cfg.LeaderElection.ResourceName, "leader-election-id", cfg.LeaderElection.ResourceName,
+		"The name of the resource used for leader election. Set a different one on each controller sharded by --docserver-selector.")
+	flag.IntVar(&cfg.Controller.MaxConcurrentReconciles, "max-concurrent-reconciles", cfg.Controller.MaxConcurrentReconciles,
+		"The maximum number of DocServers reconciled concurrently.")
+	flag.Var((*config.StringSlice)(&cfg.Controller.Namespaces), "namespaces",
+		"The comma-separated list of the namespaces to watch. All namespaces are watched if empty.")
+	flag.StringVar(&cfg.Controller.DocServerSelector, "docserver-selector", cfg.Controller.DocServerSelector,
+		"The label selector of the DocServers reconciled by this controller, e.g. shard=a. All DocServers are reconciled if empty.")
+	flag.DurationVar(&cfg.Controller.SyncPeriod.Duration, "sync-period", cfg.Controller.SyncPeriod.Duration,
+		"The minimum interval at which all the watched resources are reconciled.")
+	flag.DurationVar(&cfg.Controller.MaxRequeueInterval.Duration, "max-requeue-interval", cfg.Controller.MaxRequeueInterval.Duration,
+		"The upper bound of the exponential interval to re-check an unhealthy DocServer.")
+	flag.IntVar(&cfg.Webhook.Port, "webhook-port", cfg.Webhook.Port, "The port the webhook server listens on.")
+	flag.StringVar(&cfg.Webhook.CertDir, "webhook-cert-dir", cfg.Webhook.CertDir,
+		"The directory that contains the serving certificate of the webhook server. The default of controller-runtime is used if empty.")
+	flag.BoolVar(&cfg.Webhook.CertRotation.Enabled, "webhook-cert-rotation", cfg.Webhook.CertRotation.Enabled,
+		"Generate and rotate the serving certificate of the webhook server in the manager instead of cert-manager.")
+	flag.StringVar(&cfg.Webhook.CertRotation.SecretName, "webhook-cert-secret", cfg.Webhook.CertRotation.SecretName,
+		"The name of the Secret that stores the rotated certificate in the namespace of the manager.")
+	flag.StringVar(&cfg.Webhook.CertRotation.ServiceName, "webhook-service-name", cfg.Webhook.CertRotation.ServiceName,
+		"The name of the webhook Service in the namespace of the manager, which is set to the rotated certificate.")
+	flag.StringVar(&cfg.Webhook.CertRotation.MutatingWebhookConfiguration, "mutating-webhook-configuration",
+		cfg.Webhook.CertRotation.MutatingWebhookConfiguration, "The name of the MutatingWebhookConfiguration into which the rotated CA is injected.")
+	flag.StringVar(&cfg.Webhook.CertRotation.ValidatingWebhookConfiguration, "validating-webhook-configuration",
+		cfg.Webhook.CertRotation.ValidatingWebhookConfiguration, "The name of the ValidatingWebhookConfiguration into which the rotated CA is injected.")
+	flag.StringVar(&cfg.DefaultImages.Server, "default-image", cfg.DefaultImages.Server,
+		"The image of the docserver container used when neither the DocServer nor its DocServerClass set it.")
+	flag.StringVar(&cfg.DefaultImages.Gitpod, "default-gitpod-image", cfg.DefaultImages.Gitpod,
+		"The image of the gitpod container used when neither the DocServer nor its DocServerClass set it.")
+	flag.Var(cliflag.NewMapStringBool(&cfg.FeatureGates), config.FeatureGatesFlag,
+		"A set of key=value pairs that enable or disable the features, e.g. SnapshotRetention=false. The known features are:\n"+
+			strings.Join(features.DefaultFeatureGate.KnownFeatures(), "\n"))
+	flag.StringVar(&tracingOpts.Endpoint, "otlp-endpoint", "",
+		"The host and port of the OTLP gRPC collector the traces are exported to. The tracing is disabled if empty.")
+	flag.BoolVar(&tracingOpts.Insecure, "otlp-insecure", false, "Disable TLS of the connection to the OTLP collector.")
+	flag.Float64Var(&tracingOpts.SamplingRatio, "trace-sampling-ratio", 1, "The ratio of the reconciles that are traced.")
+	// The logs are written in JSON by default. --zap-devel switches to the human readable console logs.
+	opts := zap.Options{}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if len(configFile) != 0 {
+		if err := cfg.Load(configFile, flag.CommandLine); err != nil {
+			setupLog.Error(err, "unable to load the configuration file", "path", configFile)
+			os.Exit(1)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+
+	if err := features.DefaultMutableFeatureGate.SetFromMap(cfg.FeatureGates); err != nil {
+		setupLog.Error(err, "unable to set feature gates")
+		os.Exit(1)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+
+	tracingOpts.ServiceName = "docserver-controller"
+	shutdownTracing, err := tracing.Setup(ctx, tracingOpts)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			setupLog.Error(err, "unable to shut down tracing")
+		}
+	}()
+
+	// The selector has been validated with the configuration.
+	selector, _ := labels.Parse(cfg.Controller.DocServerSelector)
+
+	enableWebhooks := os.Getenv("ENABLE_WEBHOOKS") != "false"
+	if enableWebhooks && cfg.Webhook.CertRotation.Enabled && len(cfg.Webhook.CertDir) == 0 {
+		// The default directory of the webhook server, where the rotated certificate is written.
+		cfg.Webhook.CertDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "5cfe0d44.git-ogawa.github.io",
+		MetricsBindAddress:     cfg.Metrics.BindAddress,
+		Port:                   cfg.Webhook.Port,
+		CertDir:                cfg.Webhook.CertDir,
+		HealthProbeBindAddress: cfg.Health.HealthProbeBindAddress,
+		SyncPeriod:             &cfg.Controller.SyncPeriod.Duration,
+		NewCache:               newCache(cfg.Controller.Namespaces, selector),
+		LeaderElection:         cfg.LeaderElection.LeaderElect,
+		LeaderElectionID:       cfg.LeaderElection.ResourceName,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -90,36 +187,143 @@ func main() {
 	}
 
 	if err = (&controller.DocServerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Tracing:                 tracingOpts,
+		MaxRequeueInterval:      cfg.Controller.MaxRequeueInterval.Duration,
+		MaxConcurrentReconciles: cfg.Controller.MaxConcurrentReconciles,
+		DefaultImage:            cfg.DefaultImages.Server,
+		DefaultGitpodImage:      cfg.DefaultImages.Gitpod,
+		Recorder:                mgr.GetEventRecorderFor("docserver-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DocServer")
 		os.Exit(1)
 	}
-	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
-		if err = (&updatev1beta1.DocServer{}).SetupWebhookWithManager(mgr); err != nil {
-			setupLog.Error(err, "unable to create webhook", "webhook", "CronJob")
+	if enableWebhooks {
+		if err = (&updatev1.DocServer{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DocServer")
+			os.Exit(1)
+		}
+		if err = (&updatev1.DocServerClass{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "DocServerClass")
 			os.Exit(1)
 		}
 	}
-	// if err = (&updatev1beta1.DocServer{}).SetupWebhookWithManager(mgr); err != nil {
-	// 	setupLog.Error(err, "unable to create webhook", "webhook", "DocServer")
-	// 	os.Exit(1)
-	// }
+	if err = mgr.Add(&migration.StorageVersionMigrator{
+		Client: mgr.GetClient(),
+		Reader: mgr.GetAPIReader(),
+		Resources: []schema.GroupResource{
+			{Group: updatev1.GroupVersion.Group, Resource: "docservers"},
+			{Group: updatev1.GroupVersion.Group, Resource: "docserverclasses"},
+		},
+		Backoff: migration.DefaultBackoff,
+		Log:     ctrl.Log.WithName("migration"),
+	}); err != nil {
+		setupLog.Error(err, "unable to set up storage version migration")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
+	if enableWebhooks && cfg.Webhook.CertRotation.Enabled {
+		namespace, err := managerNamespace()
+		if err != nil {
+			setupLog.Error(err, "unable to get the namespace of the manager")
+			os.Exit(1)
+		}
+		rotation := cfg.Webhook.CertRotation
+		clusterDomain := os.Getenv("KUBERNETES_CLUSTER_DOMAIN")
+		if len(clusterDomain) == 0 {
+			clusterDomain = "cluster.local"
+		}
+		rotator := &certrotator.CertRotator{
+			Client:                         mgr.GetClient(),
+			Reader:                         mgr.GetAPIReader(),
+			SecretKey:                      types.NamespacedName{Namespace: namespace, Name: rotation.SecretName},
+			DNSNames:                       certrotator.ServiceDNSNames(rotation.ServiceName, namespace, clusterDomain),
+			CertDir:                        cfg.Webhook.CertDir,
+			MutatingWebhookConfiguration:   rotation.MutatingWebhookConfiguration,
+			ValidatingWebhookConfiguration: rotation.ValidatingWebhookConfiguration,
+			CustomResourceDefinitions: []string{
+				schema.GroupResource{Group: updatev1.GroupVersion.Group, Resource: "docservers"}.String(),
+			},
+			Validity:      certrotator.DefaultValidity,
+			RotateBefore:  certrotator.DefaultRotateBefore,
+			CheckInterval: certrotator.DefaultCheckInterval,
+			Log:           ctrl.Log.WithName("certrotator"),
+		}
+		// The certificate must exist before the webhook server starts.
+		if err := rotator.Ensure(ctx); err != nil {
+			setupLog.Error(err, "unable to set up webhook certificate")
+			os.Exit(1)
+		}
+		if err := mgr.Add(rotator); err != nil {
+			setupLog.Error(err, "unable to set up webhook certificate rotation")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	if err := mgr.AddReadyzCheck("cache-sync", health.CacheSynced(mgr.GetCache(), controller.WatchedObjects()...)); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err := mgr.AddReadyzCheck("webhook", health.WebhookServing(mgr.GetWebhookServer())); err != nil {
+			setupLog.Error(err, "unable to set up ready check")
+			os.Exit(1)
+		}
+	}
+
+	// The leader status is exposed by the docserver_controller_leader metric, which is 1 also when the leader
+	// election is disabled unlike leader_election_master_status of controller-runtime.
+	go func() {
+		select {
+		case <-mgr.Elected():
+			controller.RecordElected()
+			setupLog.Info("became the leader", "leaderElection", cfg.LeaderElection.LeaderElect)
+		case <-ctx.Done():
+		}
+	}()
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// managerNamespace returns the namespace where the manager runs.
+func managerNamespace() (string, error) {
+	if ns := os.Getenv("POD_NAMESPACE"); len(ns) != 0 {
+		return ns, nil
+	}
+	data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// newCache returns the function that creates the cache restricted to the namespaces,
+// and to the DocServers matching the selector.
+func newCache(namespaces []string, selector labels.Selector) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		if !selector.Empty() {
+			opts.SelectorsByObject = cache.SelectorsByObject{
+				&updatev1.DocServer{}: {Label: selector},
+			}
+		}
+		switch len(namespaces) {
+		case 0:
+			return cache.New(config, opts)
+		case 1:
+			opts.Namespace = namespaces[0]
+			return cache.New(config, opts)
+		default:
+			return cache.MultiNamespacedCacheBuilder(namespaces)(config, opts)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+)
+
+func TestNewCache(t *testing.T) {
+	if len(os.Getenv("KUBEBUILDER_ASSETS")) == 0 {
+		t.Skip("KUBEBUILDER_ASSETS is not set, run the tests with make test")
+	}
+
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := testEnv.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+	k8sClient, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// The DocServers named after their namespace, and whether or not they match the selector.
+	for _, ns := range []string{"watched", "also-watched", "other"} {
+		if err := k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}); err != nil {
+			t.Fatal(err)
+		}
+		for name, labels := range map[string]map[string]string{"managed": {"docs": "managed"}, "unlabeled": nil} {
+			ds := &updatev1.DocServer{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns, Labels: labels},
+				Spec: updatev1.DocServerSpec{Source: updatev1.Source{Git: updatev1.GitSource{
+					URL:    "https://github.com/git-ogawa/docserver.git",
+					Branch: "main",
+					Depth:  1,
+				}}},
+			}
+			if err := k8sClient.Create(ctx, ds); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// The cluster-scoped objects read by the controller regardless of the namespaces.
+	if err := k8sClient.Create(ctx, &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "standard"},
+		Provisioner: "example.com/docserver",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k8sClient.Create(ctx, &updatev1.DocServerClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{updatev1.DefaultClassAnnotation: "true"}},
+		Spec:       updatev1.DocServerClassSpec{Storage: updatev1.ClassStorage{StorageClass: "standard"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		namespaces []string
+		selector   string
+		expected   []string
+	}{
+		{"all namespaces", nil, "", []string{
+			"also-watched/managed", "also-watched/unlabeled", "other/managed", "other/unlabeled", "watched/managed", "watched/unlabeled",
+		}},
+		{"single namespace", []string{"watched"}, "", []string{"watched/managed", "watched/unlabeled"}},
+		{"selector", nil, "docs=managed", []string{"also-watched/managed", "other/managed", "watched/managed"}},
+		{"single namespace and selector", []string{"watched"}, "docs=managed", []string{"watched/managed"}},
+		{"multiple namespaces and selector", []string{"watched", "also-watched"}, "docs=managed", []string{"also-watched/managed", "watched/managed"}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := labels.Parse(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+				Scheme:                 scheme,
+				MetricsBindAddress:     "0",
+				HealthProbeBindAddress: "0",
+				NewCache:               newCache(tt.namespaces, selector),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			reconciled := map[string]bool{}
+			err = ctrl.NewControllerManagedBy(mgr).
+				Named(fmt.Sprintf("docserver-%d", i)).
+				For(&updatev1.DocServer{}).
+				Complete(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					reconciled[req.String()] = true
+					return reconcile.Result{}, nil
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mgrCtx, cancel := context.WithCancel(ctx)
+			done := make(chan error)
+			go func() { done <- mgr.Start(mgrCtx) }()
+			t.Cleanup(func() {
+				cancel()
+				if err := <-done; err != nil {
+					t.Error(err)
+				}
+			})
+			if !mgr.GetCache().WaitForCacheSync(mgrCtx) {
+				t.Fatal("cache is not synced")
+			}
+
+			var list updatev1.DocServerList
+			if err := mgr.GetClient().List(ctx, &list); err != nil {
+				t.Fatal(err)
+			}
+			var cached []string
+			for _, ds := range list.Items {
+				cached = append(cached, client.ObjectKeyFromObject(&ds).String())
+			}
+			sort.Strings(cached)
+			if !reflect.DeepEqual(cached, tt.expected) {
+				t.Errorf("cached DocServers = %v, want %v", cached, tt.expected)
+			}
+
+			// The DocServers out of the cache are not reconciled, while all the others are.
+			actual := func() []string {
+				mu.Lock()
+				defer mu.Unlock()
+				var keys []string
+				for key := range reconciled {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				return keys
+			}
+			_ = wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+				return len(actual()) >= len(tt.expected), nil
+			})
+			time.Sleep(time.Second)
+			if keys := actual(); !reflect.DeepEqual(keys, tt.expected) {
+				t.Errorf("reconciled DocServers = %v, want %v", keys, tt.expected)
+			}
+
+			// The cluster-scoped objects are read through the same cache.
+			var sc storagev1.StorageClass
+			if err := mgr.GetClient().Get(ctx, client.ObjectKey{Name: "standard"}, &sc); err != nil {
+				t.Errorf("unable to get StorageClass: %v", err)
+			}
+			class, err := updatev1.ResolveDocServerClass(ctx, mgr.GetClient(), "")
+			if err != nil {
+				t.Fatalf("unable to resolve DocServerClass: %v", err)
+			}
+			if class.Name != "default" || class.Spec.Storage.StorageClass != "standard" {
+				t.Errorf("resolved DocServerClass %s with storage class %q, want default with standard", class.Name, class.Spec.Storage.StorageClass)
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certrotator generates and rotates the serving certificate of the webhook server,
+// so that the controller can be installed without cert-manager.
+package certrotator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;patch
+
+const (
+	// DefaultValidity is the default validity of the CA and the serving certificate.
+	DefaultValidity = 365 * 24 * time.Hour
+	// DefaultRotateBefore is the default time before the expiry when the certificate is rotated.
+	DefaultRotateBefore = 30 * 24 * time.Hour
+	// DefaultCheckInterval is the default interval to check the certificate.
+	DefaultCheckInterval = time.Hour
+
+	caCertKey = "ca.crt"
+	caKeyKey  = "ca.key"
+)
+
+var crdGVK = schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}
+
+// CertRotator keeps the serving certificate in the Secret valid, writes it into the directory read by
+// the webhook server, and injects the CA into the webhook configurations and the conversion webhooks of the CRDs.
+// It runs on every replica, since every replica serves the webhooks.
+type CertRotator struct {
+	// Client writes the Secret and the webhook configurations.
+	Client client.Client
+	// Reader reads the Secret and the webhook configurations from the API server directly,
+	// since the cache may not be started or may be restricted to other namespaces.
+	Reader client.Reader
+
+	SecretKey types.NamespacedName
+	// DNSNames are the names of the webhook Service in the serving certificate.
+	DNSNames []string
+	// CertDir is the directory where the webhook server reads tls.crt and tls.key.
+	CertDir string
+
+	MutatingWebhookConfiguration   string
+	ValidatingWebhookConfiguration string
+	// CustomResourceDefinitions are the names of the CRDs whose conversion webhook is served by the webhook server.
+	CustomResourceDefinitions []string
+
+	Validity      time.Duration
+	RotateBefore  time.Duration
+	CheckInterval time.Duration
+
+	Log logr.Logger
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *CertRotator) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable. It checks the certificate periodically until ctx is done.
+func (r *CertRotator) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.Ensure(ctx); err != nil {
+				r.Log.Error(err, "unable to ensure webhook certificate")
+			}
+		}
+	}
+}
+
+// Ensure rotates the certificate if it is missing or expiring, and then writes it into the directory
+// and the webhook configurations. It is called before the manager starts, so that the webhook server
+// finds the certificate at startup.
+func (r *CertRotator) Ensure(ctx context.Context) error {
+	secret, err := r.ensureSecret(ctx)
+	if err != nil {
+		return err
+	}
+	if err := r.writeFiles(secret.Data); err != nil {
+		return err
+	}
+	if err := r.injectCABundle(ctx, secret.Data[caCertKey]); err != nil {
+		return err
+	}
+	return r.injectConversionCABundle(ctx, secret.Data[caCertKey])
+}
+
+func (r *CertRotator) ensureSecret(ctx context.Context) (*corev1.Secret, error) {
+	// The Secret is written by the replicas concurrently, so the conflicts are retried with the latest Secret.
+	var err error
+	for i := 0; i < 3; i++ {
+		var secret corev1.Secret
+		err = r.Reader.Get(ctx, r.SecretKey, &secret)
+		notFound := apierrors.IsNotFound(err)
+		if err != nil && !notFound {
+			return nil, err
+		}
+		if !notFound && r.valid(secret.Data) {
+			return &secret, nil
+		}
+
+		var data map[string][]byte
+		data, err = r.generate(secret.Data)
+		if err != nil {
+			return nil, err
+		}
+		if notFound {
+			secret = corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      r.SecretKey.Name,
+					Namespace: r.SecretKey.Namespace,
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: data,
+			}
+			err = r.Client.Create(ctx, &secret)
+		} else {
+			secret.Data = data
+			err = r.Client.Update(ctx, &secret)
+		}
+		if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Log.Info("rotate webhook certificate", "secret", r.SecretKey)
+		return &secret, nil
+	}
+	return nil, err
+}
+
+// valid returns true if the certificate is signed by the CA for the DNS names, and is not expiring.
+func (r *CertRotator) valid(data map[string][]byte) bool {
+	cert, err := parseCertificate(data[corev1.TLSCertKey])
+	if err != nil {
+		return false
+	}
+	if time.Now().Add(r.RotateBefore).After(cert.NotAfter) {
+		return false
+	}
+	if _, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey]); err != nil {
+		return false
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data[caCertKey]) {
+		return false
+	}
+	for _, name := range r.DNSNames {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generate returns the new CA and serving certificate. The CA bundle keeps the previous CA while it is valid,
+// so that the replicas still serving the previous certificate are trusted until they load the new one.
+func (r *CertRotator) generate(previous map[string][]byte) (map[string][]byte, error) {
+	now := time.Now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          serialNumber(now),
+		Subject:               pkix.Name{CommonName: "docserver-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(r.Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(now.Add(time.Nanosecond)),
+		Subject:      pkix.Name{CommonName: r.DNSNames[0]},
+		DNSNames:     r.DNSNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(r.Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	caKeyPEM, err := encodePrivateKey(caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	if prev, err := parseCertificate(previous[caCertKey]); err == nil && now.Before(prev.NotAfter) {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: prev.Raw})...)
+	}
+
+	return map[string][]byte{
+		caCertKey:               bundle,
+		caKeyKey:                caKeyPEM,
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: keyPEM,
+	}, nil
+}
+
+// writeFiles writes the certificate and key into the directory if they have changed.
+// The webhook server reloads them when the files are changed.
+func (r *CertRotator) writeFiles(data map[string][]byte) error {
+	if err := os.MkdirAll(r.CertDir, 0700); err != nil {
+		return err
+	}
+	for _, name := range []string{corev1.TLSPrivateKeyKey, corev1.TLSCertKey} {
+		path := filepath.Join(r.CertDir, name)
+		current, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(current, data[name]) {
+			continue
+		}
+		if err := os.WriteFile(path, data[name], 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// injectCABundle sets the CA bundle to all the webhooks of the webhook configurations.
+// The configurations that do not exist are skipped, e.g. when the webhooks are not installed.
+func (r *CertRotator) injectCABundle(ctx context.Context, caBundle []byte) error {
+	if len(r.MutatingWebhookConfiguration) != 0 {
+		var mwc admissionregistrationv1.MutatingWebhookConfiguration
+		err := r.Reader.Get(ctx, client.ObjectKey{Name: r.MutatingWebhookConfiguration}, &mwc)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			patch := client.MergeFrom(mwc.DeepCopy())
+			changed := false
+			for i := range mwc.Webhooks {
+				if !bytes.Equal(mwc.Webhooks[i].ClientConfig.CABundle, caBundle) {
+					mwc.Webhooks[i].ClientConfig.CABundle = caBundle
+					changed = true
+				}
+			}
+			if changed {
+				if err := r.Client.Patch(ctx, &mwc, patch); err != nil {
+					return err
+				}
+				r.Log.Info("inject CA bundle", "mutatingWebhookConfiguration", mwc.Name)
+			}
+		}
+	}
+
+	if len(r.ValidatingWebhookConfiguration) != 0 {
+		var vwc admissionregistrationv1.ValidatingWebhookConfiguration
+		err := r.Reader.Get(ctx, client.ObjectKey{Name: r.ValidatingWebhookConfiguration}, &vwc)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			patch := client.MergeFrom(vwc.DeepCopy())
+			changed := false
+			for i := range vwc.Webhooks {
+				if !bytes.Equal(vwc.Webhooks[i].ClientConfig.CABundle, caBundle) {
+					vwc.Webhooks[i].ClientConfig.CABundle = caBundle
+					changed = true
+				}
+			}
+			if changed {
+				if err := r.Client.Patch(ctx, &vwc, patch); err != nil {
+					return err
+				}
+				r.Log.Info("inject CA bundle", "validatingWebhookConfiguration", vwc.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// injectConversionCABundle sets the CA bundle to the conversion webhook of the CRDs.
+// The CRDs that do not exist or do not use the conversion webhook are skipped.
+func (r *CertRotator) injectConversionCABundle(ctx context.Context, caBundle []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(caBundle)
+	for _, name := range r.CustomResourceDefinitions {
+		crd := &unstructured.Unstructured{}
+		crd.SetGroupVersionKind(crdGVK)
+		err := r.Reader.Get(ctx, client.ObjectKey{Name: name}, crd)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		strategy, _, err := unstructured.NestedString(crd.Object, "spec", "conversion", "strategy")
+		if err != nil {
+			return err
+		}
+		if strategy != "Webhook" {
+			continue
+		}
+		current, _, err := unstructured.NestedString(crd.Object, "spec", "conversion", "webhook", "clientConfig", "caBundle")
+		if err != nil {
+			return err
+		}
+		if current == encoded {
+			continue
+		}
+
+		patch := client.MergeFrom(crd.DeepCopy())
+		if err := unstructured.SetNestedField(crd.Object, encoded, "spec", "conversion", "webhook", "clientConfig", "caBundle"); err != nil {
+			return err
+		}
+		if err := r.Client.Patch(ctx, crd, patch); err != nil {
+			return err
+		}
+		r.Log.Info("inject CA bundle", "customResourceDefinition", name)
+	}
+	return nil
+}
+
+// ServiceDNSNames returns the DNS names of the webhook Service, by which the API server connects to the webhook server.
+func ServiceDNSNames(service, namespace, clusterDomain string) []string {
+	return []string{
+		fmt.Sprintf("%s.%s.svc", service, namespace),
+		fmt.Sprintf("%s.%s.svc.%s", service, namespace, clusterDomain),
+	}
+}
+
+func serialNumber(t time.Time) *big.Int {
+	return big.NewInt(t.UnixNano())
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func encodePrivateKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,465 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certrotator
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var secretKey = types.NamespacedName{Namespace: "docserver-system", Name: "webhook-server-cert"}
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// newRotator returns the CertRotator reading and writing the objects through c.
+func newRotator(t *testing.T, c client.Client) *CertRotator {
+	t.Helper()
+	return &CertRotator{
+		Client:        c,
+		Reader:        c,
+		SecretKey:     secretKey,
+		DNSNames:      ServiceDNSNames("webhook-service", "docserver-system", "cluster.local"),
+		CertDir:       t.TempDir(),
+		Validity:      DefaultValidity,
+		RotateBefore:  DefaultRotateBefore,
+		CheckInterval: DefaultCheckInterval,
+		Log:           logr.Discard(),
+	}
+}
+
+// parseBundle returns the certificates in the PEM bundle.
+func parseBundle(t *testing.T, data []byte) []*x509.Certificate {
+	t.Helper()
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func TestServiceDNSNames(t *testing.T) {
+	expected := []string{"webhook-service.docserver-system.svc", "webhook-service.docserver-system.svc.example.org"}
+	actual := ServiceDNSNames("webhook-service", "docserver-system", "example.org")
+	if len(actual) != len(expected) || actual[0] != expected[0] || actual[1] != expected[1] {
+		t.Errorf("ServiceDNSNames() = %v, want %v", actual, expected)
+	}
+}
+
+func TestEnsureCreatesSecret(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	r := newRotator(t, c)
+
+	if err := r.Ensure(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var secret corev1.Secret
+	if err := c.Get(context.Background(), secretKey, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != corev1.SecretTypeTLS {
+		t.Errorf("type = %s, want %s", secret.Type, corev1.SecretTypeTLS)
+	}
+
+	cas := parseBundle(t, secret.Data[caCertKey])
+	if len(cas) != 1 || !cas[0].IsCA {
+		t.Fatalf("CA bundle has %d certificates, want the single CA", len(cas))
+	}
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining := time.Until(cert.NotAfter); remaining < DefaultValidity-time.Minute || remaining > DefaultValidity {
+		t.Errorf("certificate expires in %s, want %s", remaining, DefaultValidity)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cas[0])
+	for _, name := range []string{"webhook-service.docserver-system.svc", "webhook-service.docserver-system.svc.cluster.local"} {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+			t.Errorf("certificate is not valid for %s: %v", name, err)
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "webhook-service.other.svc", Roots: roots}); err == nil {
+		t.Error("certificate is valid for the Service in the other namespace")
+	}
+
+	for _, name := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		data, err := os.ReadFile(filepath.Join(r.CertDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, secret.Data[name]) {
+			t.Errorf("%s is not written into the directory", name)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	r := newRotator(t, nil)
+	data, err := r.generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short := newRotator(t, nil)
+	short.Validity = time.Hour
+	expiring, err := short.generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short.Validity = -time.Minute
+	expired, err := short.generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := r.generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		data     map[string][]byte
+		dnsNames []string
+		expected bool
+	}{
+		{name: "valid", data: data, expected: true},
+		{name: "empty", data: map[string][]byte{}, expected: false},
+		{name: "expired", data: expired, expected: false},
+		{name: "within the rotation threshold", data: expiring, expected: false},
+		{
+			name:     "other DNS name",
+			data:     data,
+			dnsNames: []string{"webhook-service.other.svc"},
+			expected: false,
+		},
+		{
+			name: "mismatched key",
+			data: map[string][]byte{
+				caCertKey:               data[caCertKey],
+				corev1.TLSCertKey:       data[corev1.TLSCertKey],
+				corev1.TLSPrivateKeyKey: other[corev1.TLSPrivateKeyKey],
+			},
+			expected: false,
+		},
+		{
+			name: "signed by the other CA",
+			data: map[string][]byte{
+				caCertKey:               other[caCertKey],
+				corev1.TLSCertKey:       data[corev1.TLSCertKey],
+				corev1.TLSPrivateKeyKey: data[corev1.TLSPrivateKeyKey],
+			},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRotator(t, nil)
+			if tt.dnsNames != nil {
+				r.DNSNames = tt.dnsNames
+			}
+			if actual := r.valid(tt.data); actual != tt.expected {
+				t.Errorf("valid() = %t, want %t", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEnsureRotatesExpiringCertificate(t *testing.T) {
+	short := newRotator(t, nil)
+	short.Validity = time.Hour
+	previous, err := short.generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretKey.Name, Namespace: secretKey.Namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data:       previous,
+	}).Build()
+	r := newRotator(t, c)
+
+	if err := r.Ensure(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var secret corev1.Secret
+	if err := c.Get(context.Background(), secretKey, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(secret.Data[corev1.TLSCertKey], previous[corev1.TLSCertKey]) {
+		t.Fatal("certificate within the rotation threshold is not rotated")
+	}
+	if !r.valid(secret.Data) {
+		t.Error("rotated certificate is not valid")
+	}
+
+	// The previous CA is kept in the bundle, so the replicas still serving the previous certificate are trusted.
+	cas := parseBundle(t, secret.Data[caCertKey])
+	if len(cas) != 2 {
+		t.Fatalf("CA bundle has %d certificates, want 2", len(cas))
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(secret.Data[caCertKey])
+	cert, err := parseCertificate(previous[corev1.TLSCertKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: r.DNSNames[0], Roots: roots}); err != nil {
+		t.Errorf("previous certificate is not trusted by the new CA bundle: %v", err)
+	}
+}
+
+func TestGenerateDropsExpiredCA(t *testing.T) {
+	r := newRotator(t, nil)
+	r.Validity = -time.Minute
+	expired, err := r.generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Validity = DefaultValidity
+	data, err := r.generate(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cas := parseBundle(t, data[caCertKey]); len(cas) != 1 {
+		t.Errorf("CA bundle has %d certificates, want the expired CA to be dropped", len(cas))
+	}
+}
+
+// racingClient simulates the other replica that writes the Secret just before this replica does.
+type racingClient struct {
+	client.Client
+	replica map[string][]byte
+	races   int
+}
+
+func (c *racingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if c.races == 0 {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+	c.races--
+	secret := obj.(*corev1.Secret).DeepCopy()
+	secret.Data = c.replica
+	if err := c.Client.Create(ctx, secret, opts...); err != nil {
+		return err
+	}
+	return apierrors.NewAlreadyExists(corev1.Resource("secrets"), secret.Name)
+}
+
+func (c *racingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.races == 0 {
+		return c.Client.Update(ctx, obj, opts...)
+	}
+	c.races--
+	secret := obj.(*corev1.Secret).DeepCopy()
+	secret.Data = c.replica
+	if err := c.Client.Update(ctx, secret, opts...); err != nil {
+		return err
+	}
+	return apierrors.NewConflict(corev1.Resource("secrets"), secret.Name, nil)
+}
+
+func TestEnsureSecretRetries(t *testing.T) {
+	replica, err := newRotator(t, nil).generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretKey.Name, Namespace: secretKey.Namespace},
+		Type:       corev1.SecretTypeTLS,
+	}
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		races   int
+	}{
+		{name: "already exists", races: 1},
+		{name: "conflict", objects: []client.Object{invalid}, races: 1},
+		{name: "conflict twice", objects: []client.Object{invalid}, races: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &racingClient{
+				Client:  fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(tt.objects...).Build(),
+				replica: replica,
+				races:   tt.races,
+			}
+			if tt.races > 1 {
+				// The other replica keeps the Secret invalid, so this replica rotates it at last.
+				c.replica = map[string][]byte{}
+			}
+			r := newRotator(t, c)
+
+			secret, err := r.ensureSecret(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.races == 1 && !bytes.Equal(secret.Data[corev1.TLSCertKey], replica[corev1.TLSCertKey]) {
+				t.Error("certificate written by the other replica is not used")
+			}
+			if !r.valid(secret.Data) {
+				t.Error("certificate is not valid")
+			}
+		})
+	}
+}
+
+func TestEnsureSecretGivesUpConflicts(t *testing.T) {
+	c := &racingClient{
+		Client: fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretKey.Name, Namespace: secretKey.Namespace},
+			Type:       corev1.SecretTypeTLS,
+		}).Build(),
+		replica: map[string][]byte{},
+		races:   3,
+	}
+	_, err := newRotator(t, c).ensureSecret(context.Background())
+	if !apierrors.IsConflict(err) {
+		t.Errorf("ensureSecret() error = %v, want conflict", err)
+	}
+}
+
+func TestInjectCABundle(t *testing.T) {
+	caBundle := []byte("ca")
+	webhookClientConfig := admissionregistrationv1.WebhookClientConfig{CABundle: []byte("old")}
+	mwc := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "mutating"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "mdocserver.kb.io", ClientConfig: webhookClientConfig},
+			{Name: "mdocserverclass.kb.io", ClientConfig: webhookClientConfig},
+		},
+	}
+	vwc := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "validating"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "vdocserver.kb.io", ClientConfig: webhookClientConfig},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(mwc, vwc).Build()
+	r := newRotator(t, c)
+	r.MutatingWebhookConfiguration = mwc.Name
+	r.ValidatingWebhookConfiguration = vwc.Name
+
+	if err := r.injectCABundle(context.Background(), caBundle); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(mwc), mwc); err != nil {
+		t.Fatal(err)
+	}
+	for _, webhook := range mwc.Webhooks {
+		if !bytes.Equal(webhook.ClientConfig.CABundle, caBundle) {
+			t.Errorf("CA bundle is not injected into %s", webhook.Name)
+		}
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(vwc), vwc); err != nil {
+		t.Fatal(err)
+	}
+	for _, webhook := range vwc.Webhooks {
+		if !bytes.Equal(webhook.ClientConfig.CABundle, caBundle) {
+			t.Errorf("CA bundle is not injected into %s", webhook.Name)
+		}
+	}
+}
+
+func TestInjectCABundleSkipsMissingConfigurations(t *testing.T) {
+	r := newRotator(t, fake.NewClientBuilder().WithScheme(newScheme(t)).Build())
+	r.MutatingWebhookConfiguration = "mutating"
+	r.ValidatingWebhookConfiguration = "validating"
+
+	if err := r.injectCABundle(context.Background(), []byte("ca")); err != nil {
+		t.Errorf("injectCABundle() error = %v, want the missing configurations to be skipped", err)
+	}
+}
+
+func TestInjectConversionCABundle(t *testing.T) {
+	caBundle := []byte("ca")
+	webhook := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "docservers.update.git-ogawa.github.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Conversion: &apiextensionsv1.CustomResourceConversion{
+				Strategy: apiextensionsv1.WebhookConverter,
+				Webhook: &apiextensionsv1.WebhookConversion{
+					ClientConfig:             &apiextensionsv1.WebhookClientConfig{CABundle: []byte("old")},
+					ConversionReviewVersions: []string{"v1"},
+				},
+			},
+		},
+	}
+	none := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "docserverclasses.update.git-ogawa.github.io"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Conversion: &apiextensionsv1.CustomResourceConversion{Strategy: apiextensionsv1.NoneConverter},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(webhook, none).Build()
+	r := newRotator(t, c)
+	r.CustomResourceDefinitions = []string{webhook.Name, none.Name, "missing.update.git-ogawa.github.io"}
+
+	if err := r.injectConversionCABundle(context.Background(), caBundle); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(webhook), webhook); err != nil {
+		t.Fatal(err)
+	}
+	if actual := webhook.Spec.Conversion.Webhook.ClientConfig.CABundle; !bytes.Equal(actual, caBundle) {
+		t.Errorf("conversion CA bundle = %q, want %q", actual, caBundle)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(none), none); err != nil {
+		t.Fatal(err)
+	}
+	if none.Spec.Conversion.Webhook != nil {
+		t.Errorf("CA bundle is injected into the CRD without the conversion webhook: %+v", none.Spec.Conversion.Webhook)
+	}
+}
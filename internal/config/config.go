@@ -0,0 +1,268 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config defines the configuration file of the controller manager.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	"github.com/git-ogawa/docserver/internal/features"
+)
+
+const (
+	// APIVersion is the version of the configuration file.
+	APIVersion = "config.docserver.git-ogawa.github.io/v1alpha1"
+	// Kind is the kind of the configuration file.
+	Kind = "ControllerConfig"
+	// FeatureGatesFlag is the name of the flag bound to FeatureGates.
+	FeatureGatesFlag = "feature-gates"
+)
+
+// ControllerConfig is the configuration of the controller manager.
+type ControllerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// DefaultImages are the images used when neither the DocServer nor its DocServerClass set them.
+	DefaultImages DefaultImages `json:"defaultImages,omitempty"`
+	// LeaderElection is the configuration of the leader election.
+	LeaderElection LeaderElection `json:"leaderElection,omitempty"`
+	// Metrics is the configuration of the metrics endpoint.
+	Metrics Metrics `json:"metrics,omitempty"`
+	// Health is the configuration of the health probe endpoint.
+	Health Health `json:"health,omitempty"`
+	// Webhook is the configuration of the webhook server.
+	Webhook Webhook `json:"webhook,omitempty"`
+	// Controller is the configuration of the DocServer controller.
+	Controller Controller `json:"controller,omitempty"`
+	// FeatureGates enables or disables the features by name.
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// DefaultImages are the default images of the docserver and gitpod containers.
+type DefaultImages struct {
+	Server string `json:"server,omitempty"`
+	Gitpod string `json:"gitpod,omitempty"`
+}
+
+// LeaderElection is the configuration of the leader election.
+type LeaderElection struct {
+	LeaderElect  bool   `json:"leaderElect,omitempty"`
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// Metrics is the configuration of the metrics endpoint.
+type Metrics struct {
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+// Health is the configuration of the health probe endpoint.
+type Health struct {
+	HealthProbeBindAddress string `json:"healthProbeBindAddress,omitempty"`
+}
+
+// Webhook is the configuration of the webhook server.
+type Webhook struct {
+	Port    int    `json:"port,omitempty"`
+	CertDir string `json:"certDir,omitempty"`
+	// CertRotation is the configuration of the serving certificate managed by the manager itself.
+	CertRotation CertRotation `json:"certRotation,omitempty"`
+}
+
+// CertRotation is the configuration of the serving certificate managed by the manager instead of cert-manager.
+type CertRotation struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// SecretName is the name of the Secret that stores the certificate in the namespace of the manager.
+	SecretName string `json:"secretName,omitempty"`
+	// ServiceName is the name of the webhook Service in the namespace of the manager.
+	ServiceName string `json:"serviceName,omitempty"`
+	// MutatingWebhookConfiguration and ValidatingWebhookConfiguration are the names of the
+	// webhook configurations into which the CA is injected.
+	MutatingWebhookConfiguration   string `json:"mutatingWebhookConfiguration,omitempty"`
+	ValidatingWebhookConfiguration string `json:"validatingWebhookConfiguration,omitempty"`
+}
+
+// Controller is the configuration of the DocServer controller.
+type Controller struct {
+	MaxConcurrentReconciles int             `json:"maxConcurrentReconciles,omitempty"`
+	MaxRequeueInterval      metav1.Duration `json:"maxRequeueInterval,omitempty"`
+	SyncPeriod              metav1.Duration `json:"syncPeriod,omitempty"`
+	Namespaces              []string        `json:"namespaces,omitempty"`
+	DocServerSelector       string          `json:"docServerSelector,omitempty"`
+}
+
+// Default returns the configuration used when neither the file nor the flags set the values.
+func Default() ControllerConfig {
+	return ControllerConfig{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: APIVersion,
+			Kind:       Kind,
+		},
+		DefaultImages: DefaultImages{
+			Server: updatev1.DefaultImage,
+			Gitpod: updatev1.DefaultGitpodImage,
+		},
+		LeaderElection: LeaderElection{
+			ResourceName: "5cfe0d44.git-ogawa.github.io",
+		},
+		Metrics: Metrics{
+			BindAddress: ":8080",
+		},
+		Health: Health{
+			HealthProbeBindAddress: ":8081",
+		},
+		Webhook: Webhook{
+			Port: 9443,
+			CertRotation: CertRotation{
+				SecretName:                     "webhook-server-cert",
+				ServiceName:                    "docserver-webhook-service",
+				MutatingWebhookConfiguration:   "docserver-mutating-webhook-configuration",
+				ValidatingWebhookConfiguration: "docserver-validating-webhook-configuration",
+			},
+		},
+		Controller: Controller{
+			MaxConcurrentReconciles: 1,
+			MaxRequeueInterval:      metav1.Duration{Duration: 5 * time.Minute},
+			SyncPeriod:              metav1.Duration{Duration: 10 * time.Hour},
+		},
+	}
+}
+
+// Load reads the configuration file at path into c. The flags set explicitly on fs are
+// bound to the fields of c, and are applied again over the file so that they take precedence.
+// The feature gates of the flag are merged over those of the file per key.
+func (c *ControllerConfig) Load(path string, fs *flag.FlagSet) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	loaded := Default()
+	if err := yaml.UnmarshalStrict(data, &loaded); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	if loaded.APIVersion != APIVersion || loaded.Kind != Kind {
+		return fmt.Errorf("invalid configuration file %s: expected %s %s, got %s %s",
+			path, APIVersion, Kind, loaded.APIVersion, loaded.Kind)
+	}
+
+	set := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	flagGates := c.FeatureGates
+	*c = loaded
+	for name, value := range set {
+		if name == FeatureGatesFlag {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	if _, ok := set[FeatureGatesFlag]; ok && len(flagGates) != 0 {
+		if c.FeatureGates == nil {
+			c.FeatureGates = map[string]bool{}
+		}
+		for name, enabled := range flagGates {
+			c.FeatureGates[name] = enabled
+		}
+	}
+	return nil
+}
+
+// Validate returns the error if the configuration is invalid.
+func (c *ControllerConfig) Validate() error {
+	var errs field.ErrorList
+
+	if len(c.DefaultImages.Server) == 0 {
+		errs = append(errs, field.Required(field.NewPath("defaultImages", "server"), "The default server image is required."))
+	}
+	if len(c.DefaultImages.Gitpod) == 0 {
+		errs = append(errs, field.Required(field.NewPath("defaultImages", "gitpod"), "The default gitpod image is required."))
+	}
+	if c.LeaderElection.LeaderElect && len(c.LeaderElection.ResourceName) == 0 {
+		errs = append(errs, field.Required(field.NewPath("leaderElection", "resourceName"), "The resource name is required for leader election."))
+	}
+	if c.Webhook.Port <= 0 || c.Webhook.Port > 65535 {
+		errs = append(errs, field.Invalid(field.NewPath("webhook", "port"), c.Webhook.Port, "The port must be between 1 and 65535."))
+	}
+
+	if rotation := c.Webhook.CertRotation; rotation.Enabled {
+		rotationPath := field.NewPath("webhook", "certRotation")
+		if len(rotation.SecretName) == 0 {
+			errs = append(errs, field.Required(rotationPath.Child("secretName"), "The Secret name is required for the certificate rotation."))
+		}
+		if len(rotation.ServiceName) == 0 {
+			errs = append(errs, field.Required(rotationPath.Child("serviceName"), "The Service name is required for the certificate rotation."))
+		}
+	}
+
+	path := field.NewPath("controller")
+	if c.Controller.MaxConcurrentReconciles < 1 {
+		errs = append(errs, field.Invalid(path.Child("maxConcurrentReconciles"), c.Controller.MaxConcurrentReconciles, "It must be at least 1."))
+	}
+	if c.Controller.MaxRequeueInterval.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("maxRequeueInterval"), c.Controller.MaxRequeueInterval.Duration.String(), "It must be positive."))
+	}
+	if c.Controller.SyncPeriod.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("syncPeriod"), c.Controller.SyncPeriod.Duration.String(), "It must be positive."))
+	}
+	for i, ns := range c.Controller.Namespaces {
+		if len(ns) == 0 {
+			errs = append(errs, field.Invalid(path.Child("namespaces").Index(i), ns, "The namespace must not be empty."))
+		}
+	}
+	if _, err := labels.Parse(c.Controller.DocServerSelector); err != nil {
+		errs = append(errs, field.Invalid(path.Child("docServerSelector"), c.Controller.DocServerSelector, err.Error()))
+	}
+
+	if err := features.DefaultMutableFeatureGate.DeepCopy().SetFromMap(c.FeatureGates); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("featureGates"), c.FeatureGates, err.Error()))
+	}
+
+	return errs.ToAggregate()
+}
+
+// StringSlice is the flag.Value of the comma-separated list.
+type StringSlice []string
+
+// String implements flag.Value.
+func (s *StringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set implements flag.Value.
+func (s *StringSlice) Set(value string) error {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			values = append(values, v)
+		}
+	}
+	*s = values
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	cliflag "k8s.io/component-base/cli/flag"
+)
+
+func TestLoad(t *testing.T) {
+	const file = `apiVersion: config.docserver.git-ogawa.github.io/v1alpha1
+kind: ControllerConfig
+leaderElection:
+  resourceName: file.git-ogawa.github.io
+controller:
+  maxConcurrentReconciles: 4
+featureGates:
+  SnapshotRetention: false
+  CustomMetricsAutoscaling: false
+`
+	tests := []struct {
+		name                        string
+		args                        []string
+		wantFeatureGates            map[string]bool
+		wantResourceName            string
+		wantMaxConcurrentReconciles int
+	}{
+		{
+			name:                        "file",
+			wantFeatureGates:            map[string]bool{"SnapshotRetention": false, "CustomMetricsAutoscaling": false},
+			wantResourceName:            "file.git-ogawa.github.io",
+			wantMaxConcurrentReconciles: 4,
+		},
+		{
+			name:                        "flags take precedence over file",
+			args:                        []string{"--leader-election-id=flag.git-ogawa.github.io", "--max-concurrent-reconciles=2"},
+			wantFeatureGates:            map[string]bool{"SnapshotRetention": false, "CustomMetricsAutoscaling": false},
+			wantResourceName:            "flag.git-ogawa.github.io",
+			wantMaxConcurrentReconciles: 2,
+		},
+		{
+			name:                        "feature gates are merged per key",
+			args:                        []string{"--feature-gates=CustomMetricsAutoscaling=true"},
+			wantFeatureGates:            map[string]bool{"SnapshotRetention": false, "CustomMetricsAutoscaling": true},
+			wantResourceName:            "file.git-ogawa.github.io",
+			wantMaxConcurrentReconciles: 4,
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.StringVar(&cfg.LeaderElection.ResourceName, "leader-election-id", cfg.LeaderElection.ResourceName, "")
+			fs.IntVar(&cfg.Controller.MaxConcurrentReconciles, "max-concurrent-reconciles", cfg.Controller.MaxConcurrentReconciles, "")
+			fs.Var(cliflag.NewMapStringBool(&cfg.FeatureGates), FeatureGatesFlag, "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := cfg.Load(path, fs); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(cfg.FeatureGates, tt.wantFeatureGates) {
+				t.Errorf("FeatureGates = %v, want %v", cfg.FeatureGates, tt.wantFeatureGates)
+			}
+			if cfg.LeaderElection.ResourceName != tt.wantResourceName {
+				t.Errorf("ResourceName = %q, want %q", cfg.LeaderElection.ResourceName, tt.wantResourceName)
+			}
+			if cfg.Controller.MaxConcurrentReconciles != tt.wantMaxConcurrentReconciles {
+				t.Errorf("MaxConcurrentReconciles = %d, want %d", cfg.Controller.MaxConcurrentReconciles, tt.wantMaxConcurrentReconciles)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
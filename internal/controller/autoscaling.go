@@ -0,0 +1,188 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	"github.com/git-ogawa/docserver/internal/features"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	autoscalingv2apply "k8s.io/client-go/applyconfigurations/autoscaling/v2"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultTargetCPUUtilizationPercentage is the target of the CPU utilization used when no metric is set.
+const defaultTargetCPUUtilizationPercentage = int32(80)
+
+func (r *DocServerReconciler) reconcileHorizontalPodAutoscaler(ctx context.Context, ds updatev1.DocServer) (err error) {
+	ctx, span := startSpan(ctx, "reconcileHorizontalPodAutoscaler", ds)
+	defer func() { endSpan(span, err) }()
+
+	hpaName := "docserver-" + ds.Name
+	depName := "docserver-" + ds.Name
+	logger := childLogger(ctx, "HorizontalPodAutoscaler", hpaName)
+
+	var current autoscalingv2.HorizontalPodAutoscaler
+	err = r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: hpaName}, &current)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	autoscaling := ds.Spec.Autoscaling
+	if autoscaling == nil {
+		if err != nil || !metav1.IsControlledBy(&current, &ds) {
+			return nil
+		}
+		err = r.Delete(ctx, &current)
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable to delete HorizontalPodAutoscaler")
+			return err
+		}
+		logger.Info("delete HorizontalPodAutoscaler successfully")
+		return nil
+	}
+
+	owner, err := controllerReference(ds, r.Scheme)
+	if err != nil {
+		return err
+	}
+
+	spec := autoscalingv2apply.HorizontalPodAutoscalerSpec().
+		WithScaleTargetRef(autoscalingv2apply.CrossVersionObjectReference().
+			WithAPIVersion(appsv1.SchemeGroupVersion.String()).
+			WithKind("Deployment").
+			WithName(depName),
+		).
+		WithMaxReplicas(autoscaling.MaxReplicas)
+	if autoscaling.MinReplicas != nil {
+		spec.WithMinReplicas(*autoscaling.MinReplicas)
+	}
+
+	// The requests per second are ignored when the feature has been disabled after the DocServer was created.
+	rps := autoscaling.RequestsPerSecond
+	if !features.Enabled(features.CustomMetricsAutoscaling) {
+		rps = nil
+	}
+
+	cpu := autoscaling.TargetCPUUtilizationPercentage
+	if cpu == nil && rps == nil {
+		cpu = pointer.Int32(defaultTargetCPUUtilizationPercentage)
+	}
+	if cpu != nil {
+		spec.WithMetrics(autoscalingv2apply.MetricSpec().
+			WithType(autoscalingv2.ResourceMetricSourceType).
+			WithResource(autoscalingv2apply.ResourceMetricSource().
+				WithName(corev1.ResourceCPU).
+				WithTarget(autoscalingv2apply.MetricTarget().
+					WithType(autoscalingv2.UtilizationMetricType).
+					WithAverageUtilization(*cpu),
+				),
+			),
+		)
+	}
+	if rps != nil {
+		target, err := resource.ParseQuantity(rps.TargetAverageValue)
+		if err != nil {
+			return err
+		}
+		spec.WithMetrics(autoscalingv2apply.MetricSpec().
+			WithType(autoscalingv2.PodsMetricSourceType).
+			WithPods(autoscalingv2apply.PodsMetricSource().
+				WithMetric(autoscalingv2apply.MetricIdentifier().
+					WithName(rps.MetricName),
+				).
+				WithTarget(autoscalingv2apply.MetricTarget().
+					WithType(autoscalingv2.AverageValueMetricType).
+					WithAverageValue(target),
+				),
+			),
+		)
+	}
+
+	hpa := autoscalingv2apply.HorizontalPodAutoscaler(hpaName, ds.Namespace).
+		WithLabels(map[string]string{
+			"app.kubernetes.io/name":       "mkdocs",
+			"app.kubernetes.io/instance":   ds.Name,
+			"app.kubernetes.io/created-by": "docserver-controller",
+		}).
+		WithOwnerReferences(owner).
+		WithSpec(spec)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(hpa)
+	if err != nil {
+		return err
+	}
+	patch := &unstructured.Unstructured{
+		Object: obj,
+	}
+
+	currApplyConfig, err := autoscalingv2apply.ExtractHorizontalPodAutoscaler(&current, "docserver-controller")
+	if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(hpa, currApplyConfig) {
+		logger.V(logLevelDebug).Info("HorizontalPodAutoscaler is up to date")
+		return nil
+	}
+	logApplyDiff(logger, currApplyConfig, hpa)
+
+	err = r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
+		FieldManager: "docserver-controller",
+		Force:        pointer.Bool(true),
+	})
+	if err != nil {
+		logger.Error(err, "unable to create or update HorizontalPodAutoscaler")
+		return err
+	}
+
+	r.recordApplied(&ds, &current, "HorizontalPodAutoscaler", hpaName)
+	logger.Info("reconcile HorizontalPodAutoscaler successfully")
+	return nil
+}
+
+// desiredReplicas returns the number of docserver pods that should be available.
+// It is the count desired by HorizontalPodAutoscaler if autoscaling is enabled, and spec.replicas otherwise.
+func (r *DocServerReconciler) desiredReplicas(ctx context.Context, ds updatev1.DocServer, dep appsv1.Deployment) (int32, error) {
+	if ds.Spec.Autoscaling == nil {
+		return ds.Spec.Replicas, nil
+	}
+
+	var hpa autoscalingv2.HorizontalPodAutoscaler
+	err := r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: "docserver-" + ds.Name}, &hpa)
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	if err == nil && hpa.Status.DesiredReplicas != 0 {
+		return hpa.Status.DesiredReplicas, nil
+	}
+	// The HorizontalPodAutoscaler has not computed the desired count yet.
+	if dep.Spec.Replicas != nil {
+		return *dep.Spec.Replicas, nil
+	}
+	return 1, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("DocServer autoscaling", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	It("creates, updates and deletes the HorizontalPodAutoscaler", func() {
+		ds := &updatev1.DocServer{
+			ObjectMeta: metav1.ObjectMeta{Name: "autoscaling", Namespace: "test"},
+			Spec: updatev1.DocServerSpec{
+				Source: updatev1.Source{Git: updatev1.GitSource{
+					URL:    "https://github.com/git-ogawa/docserver.git",
+					Branch: "main",
+					Depth:  1,
+				}},
+				Replicas: 3,
+				Autoscaling: &updatev1.Autoscaling{
+					MinReplicas:                    pointer.Int32(2),
+					MaxReplicas:                    5,
+					TargetCPUUtilizationPercentage: pointer.Int32(60),
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		key := types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}
+		var hpa autoscalingv2.HorizontalPodAutoscaler
+		Eventually(func() error {
+			return k8sClient.Get(ctx, key, &hpa)
+		}, timeout, interval).Should(Succeed())
+		Expect(hpa.Spec.ScaleTargetRef).To(Equal(autoscalingv2.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       key.Name,
+		}))
+		Expect(hpa.Spec.MinReplicas).To(HaveValue(BeEquivalentTo(2)))
+		Expect(hpa.Spec.MaxReplicas).To(BeEquivalentTo(5))
+		Expect(hpa.Spec.Metrics).To(HaveLen(1))
+		Expect(hpa.Spec.Metrics[0].Resource.Name).To(Equal(corev1.ResourceCPU))
+		Expect(hpa.Spec.Metrics[0].Resource.Target.AverageUtilization).To(HaveValue(BeEquivalentTo(60)))
+		Expect(hpa.OwnerReferences).To(HaveLen(1))
+		Expect(hpa.OwnerReferences[0].Name).To(Equal(ds.Name))
+
+		// The replicas is left to the HorizontalPodAutoscaler, so the controller does not own it.
+		var dep appsv1.Deployment
+		Eventually(func() error {
+			return k8sClient.Get(ctx, key, &dep)
+		}, timeout, interval).Should(Succeed())
+		applied, err := appsv1apply.ExtractDeployment(&dep, "docserver-controller")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applied.Spec.Replicas).To(BeNil())
+
+		updateDocServer(ds, func(ds *updatev1.DocServer) {
+			ds.Spec.Autoscaling.MaxReplicas = 8
+			ds.Spec.Autoscaling.TargetCPUUtilizationPercentage = nil
+		})
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, key, &hpa)).To(Succeed())
+			g.Expect(hpa.Spec.MaxReplicas).To(BeEquivalentTo(8))
+			g.Expect(hpa.Spec.Metrics).To(HaveLen(1))
+			g.Expect(hpa.Spec.Metrics[0].Resource.Target.AverageUtilization).To(HaveValue(BeEquivalentTo(defaultTargetCPUUtilizationPercentage)))
+		}, timeout, interval).Should(Succeed())
+
+		updateDocServer(ds, func(ds *updatev1.DocServer) {
+			ds.Spec.Autoscaling = nil
+		})
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(ctx, key, &hpa))
+		}, timeout, interval).Should(BeTrue())
+
+		// The replicas is applied by the controller again.
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, key, &dep)).To(Succeed())
+			applied, err := appsv1apply.ExtractDeployment(&dep, "docserver-controller")
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(applied.Spec.Replicas).To(HaveValue(BeEquivalentTo(3)))
+		}, timeout, interval).Should(Succeed())
+	})
+})
@@ -0,0 +1,124 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// classNameIndexKey is the field index of DocServers by the name of their DocServerClass.
+const classNameIndexKey = ".spec.className"
+
+func indexClassName(obj client.Object) []string {
+	return []string{obj.(*updatev1.DocServer).Spec.ClassName}
+}
+
+// findDocServersForClass returns the requests of DocServers that belong to the class.
+// The DocServers without className are included if the class is the default class.
+func (r *DocServerReconciler) findDocServersForClass(obj client.Object) []reconcile.Request {
+	requests := r.requestsForIndex(classNameIndexKey, obj)
+	if class, ok := obj.(*updatev1.DocServerClass); ok && class.IsDefault() {
+		var dsList updatev1.DocServerList
+		err := r.List(context.Background(), &dsList, client.MatchingFields{classNameIndexKey: ""})
+		if err != nil {
+			return requests
+		}
+		for _, ds := range dsList.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ds)})
+		}
+	}
+	return requests
+}
+
+// resolveClass returns the DocServerClass of the DocServer, whose empty fields are filled with the configured or built-in defaults.
+func (r *DocServerReconciler) resolveClass(ctx context.Context, ds updatev1.DocServer) (*updatev1.DocServerClass, error) {
+	return updatev1.ResolveDocServerClassWithDefaults(ctx, r.Client, ds.Spec.ClassName, updatev1.DocServerClassSpec{
+		Image:       r.DefaultImage,
+		GitpodImage: r.DefaultGitpodImage,
+	})
+}
+
+// applyPodTemplate applies the pod template of the class to the pod. The labels set by the controller are not overwritten.
+func applyPodTemplate(pod *corev1apply.PodTemplateSpecApplyConfiguration, tmpl *updatev1.PodTemplate) error {
+	if tmpl == nil {
+		return nil
+	}
+
+	for k, v := range tmpl.Labels {
+		if _, ok := pod.Labels[k]; !ok {
+			pod.WithLabels(map[string]string{k: v})
+		}
+	}
+	if len(tmpl.Annotations) != 0 {
+		pod.WithAnnotations(tmpl.Annotations)
+	}
+
+	spec := pod.Spec
+	if len(tmpl.NodeSelector) != 0 {
+		spec.WithNodeSelector(tmpl.NodeSelector)
+	}
+	if len(tmpl.PriorityClassName) != 0 {
+		spec.WithPriorityClassName(tmpl.PriorityClassName)
+	}
+	for _, t := range tmpl.Tolerations {
+		toleration := corev1apply.Toleration()
+		if err := convertApplyConfiguration(t, toleration); err != nil {
+			return err
+		}
+		spec.WithTolerations(toleration)
+	}
+	if tmpl.Affinity != nil {
+		affinity := corev1apply.Affinity()
+		if err := convertApplyConfiguration(tmpl.Affinity, affinity); err != nil {
+			return err
+		}
+		spec.WithAffinity(affinity)
+	}
+	if tmpl.SecurityContext != nil {
+		securityContext := corev1apply.PodSecurityContext()
+		if err := convertApplyConfiguration(tmpl.SecurityContext, securityContext); err != nil {
+			return err
+		}
+		spec.WithSecurityContext(securityContext)
+	}
+	if tmpl.Resources != nil && (len(tmpl.Resources.Limits) != 0 || len(tmpl.Resources.Requests) != 0) {
+		resources := corev1apply.ResourceRequirements()
+		if len(tmpl.Resources.Limits) != 0 {
+			resources.WithLimits(tmpl.Resources.Limits)
+		}
+		if len(tmpl.Resources.Requests) != 0 {
+			resources.WithRequests(tmpl.Resources.Requests)
+		}
+		spec.Containers[0].WithResources(resources)
+	}
+	return nil
+}
+
+// convertApplyConfiguration converts the API type into the corresponding apply configuration.
+func convertApplyConfiguration(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+var _ = Describe("DocServer pod options", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	It("propagates imagePullSecrets and serviceAccountName to the gitpod and docserver pods with the class", func() {
+		class := &updatev1.DocServerClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-options"},
+			Spec: updatev1.DocServerClassSpec{
+				Image:             "registry.example.com/class/mkdocs:1.0",
+				GitpodImage:       "registry.example.com/class/gitpod:1.0",
+				PodTemplate:       &updatev1.PodTemplate{NodeSelector: map[string]string{"pool": "docs"}},
+				GitpodPodTemplate: &updatev1.PodTemplate{NodeSelector: map[string]string{"pool": "sync"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, class)).To(Succeed())
+
+		ds := &updatev1.DocServer{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-options", Namespace: "test"},
+			Spec: updatev1.DocServerSpec{
+				ClassName: class.Name,
+				Source: updatev1.Source{Git: updatev1.GitSource{
+					URL:    "https://github.com/git-ogawa/docserver.git",
+					Branch: "main",
+					Depth:  1,
+				}},
+				Replicas:           1,
+				Server:             updatev1.Server{Image: "registry.example.com/docs/mkdocs:2.0"},
+				ImagePullSecrets:   []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}},
+				ServiceAccountName: "docs",
+			},
+		}
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		var job batchv1.Job
+		var dep appsv1.Deployment
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "gitpod-" + ds.Name}, &job)).To(Succeed())
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}, &dep)).To(Succeed())
+		}, timeout, interval).Should(Succeed())
+
+		for _, spec := range []corev1.PodSpec{job.Spec.Template.Spec, dep.Spec.Template.Spec} {
+			Expect(spec.ImagePullSecrets).To(Equal(ds.Spec.ImagePullSecrets))
+			Expect(spec.ServiceAccountName).To(Equal("docs"))
+		}
+
+		// The image of the DocServer takes precedence over the class, and the class fills the others.
+		Expect(dep.Spec.Template.Spec.Containers[0].Image).To(Equal("registry.example.com/docs/mkdocs:2.0"))
+		Expect(job.Spec.Template.Spec.Containers[0].Image).To(Equal("registry.example.com/class/gitpod:1.0"))
+		Expect(dep.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{"pool": "docs"}))
+		Expect(job.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{"pool": "sync"}))
+	})
+})
+
+func TestApplyPodOptions(t *testing.T) {
+	ds := updatev1.DocServer{
+		ObjectMeta: metav1.ObjectMeta{Name: "docs"},
+		Spec: updatev1.DocServerSpec{
+			ImagePullSecrets:   []corev1.LocalObjectReference{{Name: "registry"}},
+			ServiceAccountName: "docs",
+		},
+	}
+	tmpl := &updatev1.PodTemplate{
+		Labels:            map[string]string{"app.kubernetes.io/instance": "class", "team": "docs"},
+		NodeSelector:      map[string]string{"pool": "docs"},
+		PriorityClassName: "low",
+	}
+
+	pod := corev1apply.PodTemplateSpec().
+		WithLabels(serverPodLabels(ds)).
+		WithSpec(corev1apply.PodSpec().WithContainers(corev1apply.Container().WithName("mkdocs")))
+	applyPodOptions(pod.Spec, ds)
+	if err := applyPodTemplate(pod, tmpl); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pod.Spec.ImagePullSecrets) != 1 || *pod.Spec.ImagePullSecrets[0].Name != "registry" {
+		t.Errorf("imagePullSecrets = %v, want registry", pod.Spec.ImagePullSecrets)
+	}
+	if pod.Spec.ServiceAccountName == nil || *pod.Spec.ServiceAccountName != "docs" {
+		t.Errorf("serviceAccountName = %v, want docs", pod.Spec.ServiceAccountName)
+	}
+	if pod.Spec.PriorityClassName == nil || *pod.Spec.PriorityClassName != "low" || pod.Spec.NodeSelector["pool"] != "docs" {
+		t.Errorf("the class template is not applied with the pod options: %v, %v", pod.Spec.PriorityClassName, pod.Spec.NodeSelector)
+	}
+	// The labels of the class do not override the labels selecting the pods.
+	if pod.Labels["app.kubernetes.io/instance"] != "docs" || pod.Labels["team"] != "docs" {
+		t.Errorf("labels = %v, want the instance label of the DocServer and the team label of the class", pod.Labels)
+	}
+
+	// The pods of the DocServer without the options use the defaults of the namespace.
+	pod = corev1apply.PodTemplateSpec().WithSpec(corev1apply.PodSpec())
+	applyPodOptions(pod.Spec, updatev1.DocServer{})
+	if len(pod.Spec.ImagePullSecrets) != 0 || pod.Spec.ServiceAccountName != nil {
+		t.Errorf("imagePullSecrets = %v and serviceAccountName = %v, want unset", pod.Spec.ImagePullSecrets, pod.Spec.ServiceAccountName)
+	}
+}
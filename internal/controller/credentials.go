@@ -0,0 +1,170 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// secretRefIndexKey is the field index of DocServers by the names of the secrets they reference.
+	secretRefIndexKey = ".spec.source.git.secretRefs"
+
+	// configMapRefIndexKey is the field index of DocServers by the names of the configmaps they reference.
+	configMapRefIndexKey = ".spec.source.git.configMapRefs"
+
+	// credentialsHashAnnotation is the annotation of the gitpod Job that stores the hash of the referenced credentials.
+	credentialsHashAnnotation = "docserver.git-ogawa.github.io/credentials-hash"
+)
+
+// referencedSecrets returns the names of the secrets referenced by the DocServer.
+func referencedSecrets(ds *updatev1.DocServer) []string {
+	var names []string
+	if len(ds.Spec.Source.Git.Auth.BasicAuthSecret) != 0 {
+		names = append(names, ds.Spec.Source.Git.Auth.BasicAuthSecret)
+	}
+	if ds.Spec.Source.Git.Auth.SSH != nil && len(ds.Spec.Source.Git.Auth.SSH.PrivateKeySecret) != 0 {
+		names = append(names, ds.Spec.Source.Git.Auth.SSH.PrivateKeySecret)
+	}
+	if len(ds.Spec.Source.Git.TLS.CASecret) != 0 {
+		names = append(names, ds.Spec.Source.Git.TLS.CASecret)
+	}
+	return names
+}
+
+// referencedConfigMaps returns the names of the configmaps referenced by the DocServer.
+func referencedConfigMaps(ds *updatev1.DocServer) []string {
+	var names []string
+	if ds.Spec.Source.Git.Auth.SSH != nil && len(ds.Spec.Source.Git.Auth.SSH.ConfigMap) != 0 {
+		names = append(names, ds.Spec.Source.Git.Auth.SSH.ConfigMap)
+	}
+	if ds.Spec.Source.Git.Auth.SSH != nil && ds.Spec.Source.Git.Auth.SSH.KnownHosts != nil && len(ds.Spec.Source.Git.Auth.SSH.KnownHosts.ConfigMap) != 0 {
+		names = append(names, ds.Spec.Source.Git.Auth.SSH.KnownHosts.ConfigMap)
+	}
+	return names
+}
+
+func indexSecretRefs(obj client.Object) []string {
+	return referencedSecrets(obj.(*updatev1.DocServer))
+}
+
+func indexConfigMapRefs(obj client.Object) []string {
+	return referencedConfigMaps(obj.(*updatev1.DocServer))
+}
+
+// requestsForIndex returns the requests of DocServers in the namespace of obj whose index matches the name of obj.
+func (r *DocServerReconciler) requestsForIndex(index string, obj client.Object) []reconcile.Request {
+	var dsList updatev1.DocServerList
+	err := r.List(context.Background(), &dsList,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{index: obj.GetName()},
+	)
+	if err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(dsList.Items))
+	for _, ds := range dsList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: ds.Namespace, Name: ds.Name},
+		})
+	}
+	return requests
+}
+
+func (r *DocServerReconciler) findDocServersForSecret(obj client.Object) []reconcile.Request {
+	return r.requestsForIndex(secretRefIndexKey, obj)
+}
+
+func (r *DocServerReconciler) findDocServersForConfigMap(obj client.Object) []reconcile.Request {
+	return r.requestsForIndex(configMapRefIndexKey, obj)
+}
+
+// credentialsHash returns the hash of the contents of the secrets and configmaps referenced by the DocServer.
+// The references that do not exist yet are skipped so that the hash changes once they are created.
+func (r *DocServerReconciler) credentialsHash(ctx context.Context, ds updatev1.DocServer) (string, error) {
+	h := sha256.New()
+
+	for _, name := range referencedSecrets(&ds) {
+		var secret corev1.Secret
+		err := r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: name}, &secret)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte("secret/" + name + "\n"))
+		for _, k := range sortedKeys(secret.Data) {
+			h.Write([]byte(k + "="))
+			h.Write(secret.Data[k])
+			h.Write([]byte("\n"))
+		}
+	}
+
+	for _, name := range referencedConfigMaps(&ds) {
+		var cm corev1.ConfigMap
+		err := r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: name}, &cm)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte("configmap/" + name + "\n"))
+		for _, k := range sortedKeys(cm.Data) {
+			h.Write([]byte(k + "=" + cm.Data[k] + "\n"))
+		}
+		for _, k := range sortedKeys(cm.BinaryData) {
+			h.Write([]byte(k + "="))
+			h.Write(cm.BinaryData[k])
+			h.Write([]byte("\n"))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// hashObject returns the hash of the JSON representation of obj.
+func hashObject(obj interface{}) (string, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
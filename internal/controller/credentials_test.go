@@ -0,0 +1,177 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("DocServer credentials", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	It("recreates the Job when the referenced Secret changes", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "basic-auth", Namespace: "test"},
+			StringData: map[string]string{"username": "docs", "password": "old"},
+		}
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+
+		ds := &updatev1.DocServer{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "test"},
+			Spec: updatev1.DocServerSpec{
+				Source: updatev1.Source{Git: updatev1.GitSource{
+					URL:    "https://github.com/git-ogawa/docserver.git",
+					Branch: "main",
+					Depth:  1,
+					Auth:   updatev1.GitAuth{BasicAuthSecret: secret.Name},
+				}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		jobKey := types.NamespacedName{Namespace: "test", Name: "gitpod-" + ds.Name}
+		var job batchv1.Job
+		Eventually(func() error {
+			return k8sClient.Get(ctx, jobKey, &job)
+		}, timeout, interval).Should(Succeed())
+		oldUID := job.UID
+		oldHash := job.Annotations[credentialsHashAnnotation]
+		Expect(oldHash).NotTo(BeEmpty())
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(secret), secret)).To(Succeed())
+		secret.StringData = map[string]string{"password": "new"}
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, jobKey, &job)).To(Succeed())
+			g.Expect(job.UID).NotTo(Equal(oldUID))
+			g.Expect(job.Annotations[credentialsHashAnnotation]).NotTo(Equal(oldHash))
+		}, timeout, interval).Should(Succeed())
+	})
+})
+
+func TestReferences(t *testing.T) {
+	ds := &updatev1.DocServer{Spec: updatev1.DocServerSpec{
+		Source: updatev1.Source{Git: updatev1.GitSource{
+			Auth: updatev1.GitAuth{
+				BasicAuthSecret: "basic-auth",
+				SSH: &updatev1.SSHAuth{
+					ConfigMap:        "ssh-config",
+					PrivateKeySecret: "ssh-key",
+					KnownHosts:       &updatev1.KnownHosts{ConfigMap: "known-hosts"},
+				},
+			},
+			TLS: updatev1.GitTLS{CASecret: "ca"},
+		}},
+	}}
+
+	if actual, expected := referencedSecrets(ds), []string{"basic-auth", "ssh-key", "ca"}; !equality.Semantic.DeepEqual(actual, expected) {
+		t.Errorf("referencedSecrets() = %v, want %v", actual, expected)
+	}
+	if actual, expected := referencedConfigMaps(ds), []string{"ssh-config", "known-hosts"}; !equality.Semantic.DeepEqual(actual, expected) {
+		t.Errorf("referencedConfigMaps() = %v, want %v", actual, expected)
+	}
+	if actual := referencedSecrets(&updatev1.DocServer{}); len(actual) != 0 {
+		t.Errorf("referencedSecrets() of the DocServer without credentials = %v, want none", actual)
+	}
+}
+
+func TestCredentialsHash(t *testing.T) {
+	ds := updatev1.DocServer{
+		ObjectMeta: metav1.ObjectMeta{Name: "docs", Namespace: "default"},
+		Spec: updatev1.DocServerSpec{
+			Source: updatev1.Source{Git: updatev1.GitSource{
+				Auth: updatev1.GitAuth{
+					BasicAuthSecret: "basic-auth",
+					SSH:             &updatev1.SSHAuth{KnownHosts: &updatev1.KnownHosts{ConfigMap: "known-hosts"}},
+				},
+			}},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "basic-auth", Namespace: "default"},
+		Data:       map[string][]byte{"username": []byte("docs"), "password": []byte("old")},
+	}
+	r, _ := newFakeReconciler(t, secret)
+	ctx := context.Background()
+
+	hash := func() string {
+		t.Helper()
+		h, err := r.credentialsHash(ctx, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	initial := hash()
+	if len(initial) != 16 {
+		t.Errorf("hash %q has %d characters, want 16", initial, len(initial))
+	}
+	if again := hash(); again != initial {
+		t.Errorf("hash of the same credentials changed from %s to %s", initial, again)
+	}
+
+	// The ConfigMap that does not exist yet changes the hash once it is created.
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "known-hosts", Namespace: "default"},
+		Data:       map[string]string{"known_hosts": "github.com ssh-ed25519 AAAA"},
+	}
+	if err := r.Create(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+	created := hash()
+	if created == initial {
+		t.Error("hash does not change when the referenced ConfigMap is created")
+	}
+
+	secret.Data["password"] = []byte("new")
+	if err := r.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	rotated := hash()
+	if rotated == created {
+		t.Error("hash does not change when the referenced Secret is rotated")
+	}
+
+	// The Secret that is not referenced does not change the hash.
+	if err := r.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("secret")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if unrelated := hash(); unrelated != rotated {
+		t.Error("hash changes by the Secret that is not referenced")
+	}
+}
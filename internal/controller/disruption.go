@@ -0,0 +1,140 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+	policyv1apply "k8s.io/client-go/applyconfigurations/policy/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// serverPodLabels returns the labels of the docserver pods. The component label distinguishes them
+// from the gitpod pods, which have the other labels in common.
+func serverPodLabels(ds updatev1.DocServer) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "mkdocs",
+		"app.kubernetes.io/instance":   ds.Name,
+		"app.kubernetes.io/created-by": "docserver-controller",
+		"app.kubernetes.io/component":  "server",
+	}
+}
+
+// podDisruptionBudgetEnabled returns whether or not PodDisruptionBudget is created for the docserver pods.
+func podDisruptionBudgetEnabled(ds updatev1.DocServer) bool {
+	if pdb := ds.Spec.PodDisruptionBudget; pdb != nil && pdb.Enabled != nil {
+		return *pdb.Enabled
+	}
+	if autoscaling := ds.Spec.Autoscaling; autoscaling != nil {
+		return autoscaling.MinReplicas != nil && *autoscaling.MinReplicas > 1
+	}
+	return ds.Spec.Replicas > 1
+}
+
+func (r *DocServerReconciler) reconcilePodDisruptionBudget(ctx context.Context, ds updatev1.DocServer) (err error) {
+	ctx, span := startSpan(ctx, "reconcilePodDisruptionBudget", ds)
+	defer func() { endSpan(span, err) }()
+
+	pdbName := "docserver-" + ds.Name
+	logger := childLogger(ctx, "PodDisruptionBudget", pdbName)
+
+	var current policyv1.PodDisruptionBudget
+	err = r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: pdbName}, &current)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if !podDisruptionBudgetEnabled(ds) {
+		if err != nil || !metav1.IsControlledBy(&current, &ds) {
+			return nil
+		}
+		err = r.Delete(ctx, &current)
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable to delete PodDisruptionBudget")
+			return err
+		}
+		logger.Info("delete PodDisruptionBudget successfully")
+		return nil
+	}
+
+	owner, err := controllerReference(ds, r.Scheme)
+	if err != nil {
+		return err
+	}
+
+	spec := policyv1apply.PodDisruptionBudgetSpec().
+		WithSelector(metav1apply.LabelSelector().WithMatchLabels(serverPodLabels(ds)))
+	switch pdb := ds.Spec.PodDisruptionBudget; {
+	case pdb != nil && pdb.MinAvailable != nil:
+		spec.WithMinAvailable(*pdb.MinAvailable)
+	case pdb != nil && pdb.MaxUnavailable != nil:
+		spec.WithMaxUnavailable(*pdb.MaxUnavailable)
+	default:
+		spec.WithMaxUnavailable(intstr.FromInt(1))
+	}
+
+	pdb := policyv1apply.PodDisruptionBudget(pdbName, ds.Namespace).
+		WithLabels(map[string]string{
+			"app.kubernetes.io/name":       "mkdocs",
+			"app.kubernetes.io/instance":   ds.Name,
+			"app.kubernetes.io/created-by": "docserver-controller",
+		}).
+		WithOwnerReferences(owner).
+		WithSpec(spec)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pdb)
+	if err != nil {
+		return err
+	}
+	patch := &unstructured.Unstructured{
+		Object: obj,
+	}
+
+	currApplyConfig, err := policyv1apply.ExtractPodDisruptionBudget(&current, "docserver-controller")
+	if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(pdb, currApplyConfig) {
+		logger.V(logLevelDebug).Info("PodDisruptionBudget is up to date")
+		return nil
+	}
+	logApplyDiff(logger, currApplyConfig, pdb)
+
+	err = r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
+		FieldManager: "docserver-controller",
+		Force:        pointer.Bool(true),
+	})
+	if err != nil {
+		logger.Error(err, "unable to create or update PodDisruptionBudget")
+		return err
+	}
+
+	r.recordApplied(&ds, &current, "PodDisruptionBudget", pdbName)
+	logger.Info("reconcile PodDisruptionBudget successfully")
+	return nil
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("DocServer disruption", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	newDocServer := func(name string, replicas int32) *updatev1.DocServer {
+		return &updatev1.DocServer{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Spec: updatev1.DocServerSpec{
+				Source: updatev1.Source{Git: updatev1.GitSource{
+					URL:    "https://github.com/git-ogawa/docserver.git",
+					Branch: "main",
+					Depth:  1,
+				}},
+				Replicas: replicas,
+			},
+		}
+	}
+
+	// pdbOf waits for the PodDisruptionBudget of the DocServer to be created.
+	pdbOf := func(ds *updatev1.DocServer) *policyv1.PodDisruptionBudget {
+		var pdb policyv1.PodDisruptionBudget
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}, &pdb)
+		}, timeout, interval).Should(Succeed())
+		return &pdb
+	}
+
+	// expectDeleted waits for the PodDisruptionBudget of the DocServer to be deleted.
+	expectDeleted := func(ds *updatev1.DocServer) {
+		Eventually(func() bool {
+			var pdb policyv1.PodDisruptionBudget
+			return errors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}, &pdb))
+		}, timeout, interval).Should(BeTrue())
+	}
+
+	It("selects only the docserver pods by the Deployment, the Service and the PodDisruptionBudget", func() {
+		ds := newDocServer("disruption-selector", 2)
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		pdb := pdbOf(ds)
+		labels := serverPodLabels(*ds)
+		Expect(pdb.Spec.Selector.MatchLabels).To(Equal(labels))
+		Expect(pdb.Spec.MaxUnavailable).To(HaveValue(Equal(intstr.FromInt(1))))
+		Expect(pdb.Spec.MinAvailable).To(BeNil())
+
+		key := types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}
+		var dep appsv1.Deployment
+		var svc corev1.Service
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, key, &dep)).To(Succeed())
+			g.Expect(k8sClient.Get(ctx, key, &svc)).To(Succeed())
+		}, timeout, interval).Should(Succeed())
+		Expect(dep.Spec.Selector.MatchLabels).To(Equal(labels))
+		Expect(dep.Spec.Template.Labels).To(Equal(labels))
+		Expect(svc.Spec.Selector).To(Equal(labels))
+	})
+
+	It("deletes the PodDisruptionBudget when the DocServer is scaled to 1", func() {
+		ds := newDocServer("disruption-scale", 2)
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+		pdbOf(ds)
+
+		updateDocServer(ds, func(ds *updatev1.DocServer) {
+			ds.Spec.Replicas = 1
+		})
+		expectDeleted(ds)
+	})
+
+	It("deletes the PodDisruptionBudget when it is disabled", func() {
+		ds := newDocServer("disruption-disabled", 3)
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+		pdbOf(ds)
+
+		updateDocServer(ds, func(ds *updatev1.DocServer) {
+			ds.Spec.PodDisruptionBudget = &updatev1.PodDisruptionBudget{Enabled: pointer.Bool(false)}
+		})
+		expectDeleted(ds)
+	})
+
+	It("switches between minAvailable and maxUnavailable", func() {
+		minAvailable := intstr.FromString("50%")
+		ds := newDocServer("disruption-exclusive", 1)
+		ds.Spec.PodDisruptionBudget = &updatev1.PodDisruptionBudget{
+			Enabled:      pointer.Bool(true),
+			MinAvailable: &minAvailable,
+		}
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		pdb := pdbOf(ds)
+		Expect(pdb.Spec.MinAvailable).To(HaveValue(Equal(minAvailable)))
+		Expect(pdb.Spec.MaxUnavailable).To(BeNil())
+
+		maxUnavailable := intstr.FromInt(2)
+		updateDocServer(ds, func(ds *updatev1.DocServer) {
+			ds.Spec.PodDisruptionBudget.MinAvailable = nil
+			ds.Spec.PodDisruptionBudget.MaxUnavailable = &maxUnavailable
+		})
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pdb), pdb)).To(Succeed())
+			g.Expect(pdb.Spec.MaxUnavailable).To(HaveValue(Equal(maxUnavailable)))
+			g.Expect(pdb.Spec.MinAvailable).To(BeNil())
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("rejects both minAvailable and maxUnavailable", func() {
+		minAvailable, maxUnavailable := intstr.FromInt(1), intstr.FromInt(1)
+		ds := newDocServer("disruption-both", 3)
+		ds.Spec.PodDisruptionBudget = &updatev1.PodDisruptionBudget{
+			MinAvailable:   &minAvailable,
+			MaxUnavailable: &maxUnavailable,
+		}
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(eventReasons(g, ds)).To(ContainElement(reasonSpecRejected))
+		}, timeout, interval).Should(Succeed())
+		var pdb policyv1.PodDisruptionBudget
+		Consistently(func() bool {
+			return errors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}, &pdb))
+		}, time.Second, interval).Should(BeTrue())
+	})
+
+	It("recreates the Deployment created with the previous selector", func() {
+		// The DocServer is not reconciled until the class is created, so that the Deployment with the
+		// previous selector is created first.
+		ds := newDocServer("legacy-selector", 1)
+		ds.Spec.ClassName = "legacy-selector"
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		owner, err := controllerReference(*ds, scheme)
+		Expect(err).NotTo(HaveOccurred())
+		legacyLabels := map[string]string{
+			"app.kubernetes.io/name":       "mkdocs",
+			"app.kubernetes.io/instance":   ds.Name,
+			"app.kubernetes.io/created-by": "docserver-controller",
+		}
+		legacy := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "docserver-" + ds.Name,
+				Namespace: "test",
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion:         *owner.APIVersion,
+					Kind:               *owner.Kind,
+					Name:               *owner.Name,
+					UID:                *owner.UID,
+					Controller:         owner.Controller,
+					BlockOwnerDeletion: owner.BlockOwnerDeletion,
+				}},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: legacyLabels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: serverPodLabels(*ds)},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "mkdocs", Image: "mkdocs"}},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, legacy)).To(Succeed())
+		Expect(k8sClient.Create(ctx, &updatev1.DocServerClass{ObjectMeta: metav1.ObjectMeta{Name: ds.Spec.ClassName}})).To(Succeed())
+
+		// The ReplicaSets are orphaned to keep the pods running, and the orphan finalizer is removed by
+		// the garbage collector, which envtest does not run.
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(legacy), legacy)).To(Succeed())
+			g.Expect(legacy.DeletionTimestamp).NotTo(BeNil())
+			g.Expect(legacy.Finalizers).To(ContainElement(metav1.FinalizerOrphanDependents))
+		}, timeout, interval).Should(Succeed())
+		legacyUID := legacy.UID
+		legacy.Finalizers = nil
+		Expect(k8sClient.Update(ctx, legacy)).To(Succeed())
+
+		var dep appsv1.Deployment
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(legacy), &dep)).To(Succeed())
+			g.Expect(dep.UID).NotTo(Equal(legacyUID))
+			g.Expect(dep.Spec.Selector.MatchLabels).To(Equal(serverPodLabels(*ds)))
+		}, timeout, interval).Should(Succeed())
+	})
+})
+
+func TestPodDisruptionBudgetEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     updatev1.DocServerSpec
+		expected bool
+	}{
+		{name: "single replica", spec: updatev1.DocServerSpec{Replicas: 1}, expected: false},
+		{name: "multiple replicas", spec: updatev1.DocServerSpec{Replicas: 2}, expected: true},
+		{
+			name:     "disabled",
+			spec:     updatev1.DocServerSpec{Replicas: 2, PodDisruptionBudget: &updatev1.PodDisruptionBudget{Enabled: pointer.Bool(false)}},
+			expected: false,
+		},
+		{
+			name:     "enabled",
+			spec:     updatev1.DocServerSpec{Replicas: 1, PodDisruptionBudget: &updatev1.PodDisruptionBudget{Enabled: pointer.Bool(true)}},
+			expected: true,
+		},
+		{
+			name:     "autoscaling from 1",
+			spec:     updatev1.DocServerSpec{Replicas: 3, Autoscaling: &updatev1.Autoscaling{MinReplicas: pointer.Int32(1), MaxReplicas: 5}},
+			expected: false,
+		},
+		{
+			name:     "autoscaling from 2",
+			spec:     updatev1.DocServerSpec{Replicas: 1, Autoscaling: &updatev1.Autoscaling{MinReplicas: pointer.Int32(2), MaxReplicas: 5}},
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := podDisruptionBudgetEnabled(updatev1.DocServer{Spec: tt.spec}); actual != tt.expected {
+				t.Errorf("podDisruptionBudgetEnabled() = %t, want %t", actual, tt.expected)
+			}
+		})
+	}
+}
@@ -18,15 +18,24 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"strconv"
+	"time"
 
-	updatev1beta1 "github.com/git-ogawa/docserver/api/v1beta1"
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	"github.com/git-ogawa/docserver/internal/tracing"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -34,67 +43,140 @@ import (
 	batchv1apply "k8s.io/client-go/applyconfigurations/batch/v1"
 	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+	networkingv1apply "k8s.io/client-go/applyconfigurations/networking/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// readyCondition is the condition type that shows whether or not the document is served.
+	readyCondition = "Ready"
+
+	// templateHashAnnotation is the annotation of the gitpod Job that stores the hash of the Job spec.
+	templateHashAnnotation = "docserver.git-ogawa.github.io/template-hash"
 )
 
 // DocServerReconciler reconciles a DocServer object
 type DocServerReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Tracing is the tracing configuration given to the gitpod Job.
+	Tracing tracing.Options
+	// MaxRequeueInterval is the upper bound of the interval to re-check an unhealthy DocServer.
+	MaxRequeueInterval time.Duration
+	// MaxConcurrentReconciles is the maximum number of DocServers reconciled concurrently.
+	MaxConcurrentReconciles int
+	// DefaultImage and DefaultGitpodImage override the built-in default images of the DocServerClass.
+	DefaultImage       string
+	DefaultGitpodImage string
+	// Recorder records the events on the DocServers.
+	Recorder record.EventRecorder
+
+	requeueBackoff workqueue.RateLimiter
 }
 
 //+kubebuilder:rbac:groups=update.git-ogawa.github.io,resources=docservers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=update.git-ogawa.github.io,resources=docservers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=update.git-ogawa.github.io,resources=docservers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=update.git-ogawa.github.io,resources=docserverclasses,verbs=get;list;watch
 
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;update;patch
 //+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the DocServer object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// It applies the child resources of the DocServer, and finalizes the DocServer being deleted.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.4/pkg/reconcile
-func (r *DocServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *DocServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
 	logger := log.FromContext(ctx)
 
-	var ds updatev1beta1.DocServer
-	err := r.Get(ctx, req.NamespacedName, &ds)
+	var ds updatev1.DocServer
+	err = r.Get(ctx, req.NamespacedName, &ds)
 	if errors.IsNotFound(err) {
+		docServerMetrics.forget(req.NamespacedName)
+		r.resetRequeue(req.NamespacedName)
 		return ctrl.Result{}, nil
 	}
 	if err != nil {
-		logger.Error(err, "unable to get DocServer", "name", req.NamespacedName)
+		logger.Error(err, "unable to get DocServer")
 		return ctrl.Result{}, err
 	}
 
+	ctx, span := startSpan(ctx, "Reconcile", ds)
+	defer func() { endSpan(span, err) }()
+	ctx = withReconcileLogger(ctx, ds)
+	logger = log.FromContext(ctx)
+	defer func() { r.recordReconcileError(&ds, err) }()
+
 	if !ds.ObjectMeta.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, ds)
+	}
+
+	// The DocServers created while the webhook was disabled are not reconciled until the spec is fixed,
+	// which triggers the reconcile again.
+	if err := ds.ValidateSpec(); err != nil {
+		logger.Info("DocServer spec is rejected", "reason", err.Error())
+		r.Recorder.Event(&ds, corev1.EventTypeWarning, reasonSpecRejected, err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(&ds, finalizerName) {
+		patch := client.MergeFrom(ds.DeepCopy())
+		controllerutil.AddFinalizer(&ds, finalizerName)
+		err = r.Patch(ctx, &ds, patch)
+		if err != nil {
+			logger.Error(err, "unable to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	class, err := r.resolveClass(ctx, ds)
+	if err != nil {
+		logger.Error(err, "unable to resolve DocServerClass", "class", ds.Spec.ClassName)
+		return ctrl.Result{}, err
+	}
+	// The DocServerClasses created while the webhook was disabled are not used until they are fixed,
+	// which triggers the reconcile again.
+	if err := class.ValidateSpec(); err != nil {
+		logger.Info("DocServerClass is rejected", "class", class.Name, "reason", err.Error())
+		r.Recorder.Event(&ds, corev1.EventTypeWarning, reasonSpecRejected, err.Error())
 		return ctrl.Result{}, nil
 	}
 
-	err = r.reconcilePersistenVolumeClaim(ctx, ds)
+	err = r.reconcilePersistenVolumeClaim(ctx, ds, class)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	err = r.reconcileJob(ctx, ds)
+	err = r.reconcileJob(ctx, ds, class)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	err = r.reconcileDeployment(ctx, ds)
+	err = r.reconcileDeployment(ctx, ds, class)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
@@ -103,34 +185,59 @@ func (r *DocServerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
-	return r.updateStatus(ctx, ds)
+	err = r.reconcileIngress(ctx, ds, class)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileHorizontalPodAutoscaler(ctx, ds)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcilePodDisruptionBudget(ctx, ds)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	commit, err := r.syncedCommit(ctx, ds)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return r.updateStatus(ctx, ds, commit)
 }
 
-func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1beta1.DocServer) error {
-	logger := log.FromContext(ctx)
+func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1.DocServer, class *updatev1.DocServerClass) (err error) {
+	ctx, span := startSpan(ctx, "reconcileJob", ds)
+	defer func() { endSpan(span, err) }()
 
 	jobName := "gitpod-" + ds.Name
-	pvcName := "docserver-" + ds.Name
+	logger := childLogger(ctx, "Job", jobName)
+	volume, err := sourceVolume(ds)
+	if err != nil {
+		return err
+	}
 
-	gitUrl := ds.Spec.Target.Url
+	gitUrl := ds.Spec.Source.Git.URL
 	branch := "main"
-	if len(ds.Spec.Target.Branch) != 0 {
-		branch = ds.Spec.Target.Branch
+	if len(ds.Spec.Source.Git.Branch) != 0 {
+		branch = ds.Spec.Source.Git.Branch
 	}
 
 	depth := 1
-	if ds.Spec.Target.Depth != 1 {
-		depth = ds.Spec.Target.Depth
+	if ds.Spec.Source.Git.Depth != 1 {
+		depth = ds.Spec.Source.Git.Depth
 	}
 
 	sslVerify := true
-	if ds.Spec.Target.SSLVerify != nil {
-		sslVerify = *ds.Spec.Target.SSLVerify
+	if ds.Spec.Source.Git.TLS.Verify != nil {
+		sslVerify = *ds.Spec.Source.Git.TLS.Verify
 	}
 
-	image := "docogawa/gitpod:latest"
-	if len(ds.Spec.Gitpod.Image) != 0 {
-		image = ds.Spec.Gitpod.Image
+	image := class.Spec.GitpodImage
+	if len(ds.Spec.Sync.Image) != 0 {
+		image = ds.Spec.Sync.Image
 	}
 
 	owner, err := controllerReference(ds, r.Scheme)
@@ -138,15 +245,23 @@ func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1beta1
 		return err
 	}
 
+	credHash, err := r.credentialsHash(ctx, ds)
+	if err != nil {
+		return err
+	}
+
 	job := batchv1apply.Job(jobName, ds.Namespace).
 		WithLabels(map[string]string{
 			"app.kubernetes.io/name":       "mkdocs",
 			"app.kubernetes.io/instance":   ds.Name,
 			"app.kubernetes.io/created-by": "docserver-controller",
 		}).
+		WithAnnotations(map[string]string{
+			credentialsHashAnnotation: credHash,
+		}).
 		WithOwnerReferences(owner).
 		WithSpec(batchv1apply.JobSpec().
-			WithBackoffLimit(5).
+			WithBackoffLimit(*class.Spec.BackoffLimit).
 			WithCompletions(1).
 			WithTemplate(corev1apply.PodTemplateSpec().
 				WithLabels(map[string]string{
@@ -159,10 +274,6 @@ func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1beta1
 						WithName("gitpod").
 						WithImage(image).
 						WithImagePullPolicy(corev1.PullIfNotPresent).
-						WithVolumeMounts(corev1apply.VolumeMount().
-							WithName("source").
-							WithMountPath("/docs"),
-						).
 						WithEnv(
 							corev1apply.EnvVar().
 								WithName("GIT_URL").
@@ -179,18 +290,46 @@ func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1beta1
 						),
 					).
 					WithRestartPolicy(corev1.RestartPolicyNever).
-					WithVolumes(corev1apply.Volume().
-						WithName("source").
-						WithPersistentVolumeClaim(corev1apply.PersistentVolumeClaimVolumeSource().
-							WithClaimName(pvcName),
-						),
-					),
+					WithVolumes(volume),
 				),
 			),
 		)
 
-	if len(ds.Spec.Target.BasicAuthSecret) != 0 {
-		basicAuthSecret := ds.Spec.Target.BasicAuthSecret
+	mountSourceVolume(&job.Spec.Template.Spec.Containers[0], ds)
+
+	if ds.Spec.Source.Git.LFS {
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env,
+			*corev1apply.EnvVar().
+				WithName("GIT_LFS").
+				WithValue("true"),
+		)
+	}
+
+	if ds.Spec.Source.Git.Submodules != nil {
+		recursive := true
+		if ds.Spec.Source.Git.Submodules.Recursive != nil {
+			recursive = *ds.Spec.Source.Git.Submodules.Recursive
+		}
+		shallow := true
+		if ds.Spec.Source.Git.Submodules.Shallow != nil {
+			shallow = *ds.Spec.Source.Git.Submodules.Shallow
+		}
+		envVars := []corev1apply.EnvVarApplyConfiguration{
+			*corev1apply.EnvVar().
+				WithName("GIT_SUBMODULES").
+				WithValue("true"),
+			*corev1apply.EnvVar().
+				WithName("GIT_SUBMODULES_RECURSIVE").
+				WithValue(strconv.FormatBool(recursive)),
+			*corev1apply.EnvVar().
+				WithName("GIT_SUBMODULES_SHALLOW").
+				WithValue(strconv.FormatBool(shallow)),
+		}
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, envVars...)
+	}
+
+	if len(ds.Spec.Source.Git.Auth.BasicAuthSecret) != 0 {
+		basicAuthSecret := ds.Spec.Source.Git.Auth.BasicAuthSecret
 		envVars := []corev1apply.EnvVarApplyConfiguration{
 			*corev1apply.EnvVar().
 				WithName("GIT_USERNAME").
@@ -212,37 +351,79 @@ func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1beta1
 		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, envVars...)
 	}
 
-	if ds.Spec.Target.SSHSecret != nil {
-		sshConfig := ds.Spec.Target.SSHSecret.Config
-		privateKey := ds.Spec.Target.SSHSecret.PrivateKey
-		volumeMounts := []corev1apply.VolumeMountApplyConfiguration{
-			*corev1apply.VolumeMount().
+	if ds.Spec.Source.Git.Auth.SSH != nil {
+		sshSecret := ds.Spec.Source.Git.Auth.SSH
+		strictHostKeyChecking := true
+		if sshSecret.StrictHostKeyChecking != nil {
+			strictHostKeyChecking = *sshSecret.StrictHostKeyChecking
+		}
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env,
+			*corev1apply.EnvVar().
+				WithName("GIT_SSH_STRICT_HOST_KEY_CHECKING").
+				WithValue(strconv.FormatBool(strictHostKeyChecking)),
+		)
+
+		var volumeMounts []corev1apply.VolumeMountApplyConfiguration
+		var volumes []corev1apply.VolumeApplyConfiguration
+		if len(sshSecret.ConfigMap) != 0 {
+			volumeMounts = append(volumeMounts, *corev1apply.VolumeMount().
 				WithName("sshconfig").
 				WithMountPath("/opt/gitpod/sshconfig").
 				WithReadOnly(true),
-			*corev1apply.VolumeMount().
-				WithName("privatekey").
-				WithMountPath("/opt/gitpod/privatekey").
-				WithReadOnly(true),
-		}
-		volumes := []corev1apply.VolumeApplyConfiguration{
-			*corev1apply.Volume().
+			)
+			volumes = append(volumes, *corev1apply.Volume().
 				WithName("sshconfig").
 				WithConfigMap(corev1apply.ConfigMapVolumeSource().
-					WithName(sshConfig),
+					WithName(sshSecret.ConfigMap),
 				),
-			*corev1apply.Volume().
+			)
+		}
+		if len(sshSecret.PrivateKeySecret) != 0 {
+			volumeMounts = append(volumeMounts, *corev1apply.VolumeMount().
+				WithName("privatekey").
+				WithMountPath("/opt/gitpod/privatekey").
+				WithReadOnly(true),
+			)
+			volumes = append(volumes, *corev1apply.Volume().
 				WithName("privatekey").
 				WithSecret(corev1apply.SecretVolumeSource().
-					WithSecretName(privateKey),
+					WithSecretName(sshSecret.PrivateKeySecret),
+				),
+			)
+		}
+		if sshSecret.KnownHosts != nil && len(sshSecret.KnownHosts.Inline) != 0 {
+			job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env,
+				*corev1apply.EnvVar().
+					WithName("GIT_SSH_KNOWN_HOSTS").
+					WithValue(sshSecret.KnownHosts.Inline),
+			)
+		}
+		if sshSecret.KnownHosts != nil && len(sshSecret.KnownHosts.ConfigMap) != 0 {
+			volumeMounts = append(volumeMounts, *corev1apply.VolumeMount().
+				WithName("knownhosts").
+				WithMountPath("/opt/gitpod/knownhosts").
+				WithReadOnly(true),
+			)
+			volumes = append(volumes, *corev1apply.Volume().
+				WithName("knownhosts").
+				WithConfigMap(corev1apply.ConfigMapVolumeSource().
+					WithName(sshSecret.KnownHosts.ConfigMap),
 				),
+			)
 		}
 		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts, volumeMounts...)
 		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, volumes...)
 	}
 
-	if len(ds.Spec.Target.TLSSecret) != 0 {
-		tlsSecret := ds.Spec.Target.TLSSecret
+	applyPodOptions(job.Spec.Template.Spec, ds)
+	err = applyPodTemplate(job.Spec.Template, class.Spec.GitpodPodTemplate)
+	if err != nil {
+		return err
+	}
+	applyCoScheduling(job.Spec.Template.Spec, ds)
+
+	if len(ds.Spec.Source.Git.TLS.CASecret) != 0 {
+		tlsSecret := ds.Spec.Source.Git.TLS.CASecret
 		volumeMount := corev1apply.VolumeMount().
 			WithName("cacert").
 			WithMountPath("/opt/gitpod/certs").
@@ -256,13 +437,22 @@ func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1beta1
 		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, *volume)
 	}
 
-	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	tracingEnv := r.Tracing.Env()
+	for _, name := range sortedKeys(tracingEnv) {
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env,
+			*corev1apply.EnvVar().
+				WithName(name).
+				WithValue(tracingEnv[name]),
+		)
+	}
+
+	templateHash, err := hashObject(job.Spec)
 	if err != nil {
 		return err
 	}
-	patch := &unstructured.Unstructured{
-		Object: obj,
-	}
+	job.WithAnnotations(map[string]string{
+		templateHashAnnotation: templateHash,
+	})
 
 	var current batchv1.Job
 	err = r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: jobName}, &current)
@@ -270,14 +460,40 @@ func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1beta1
 		return err
 	}
 
+	// The pod template of a Job is immutable, so the Job is recreated to re-run the sync
+	// when the referenced credentials have been rotated or the template has been changed.
+	if err == nil && (current.Annotations[credentialsHashAnnotation] != credHash || current.Annotations[templateHashAnnotation] != templateHash) {
+		err = r.Delete(ctx, &current, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable to delete Job")
+			return err
+		}
+		logger.Info("referenced credentials or template changed, recreate Job")
+		current = batchv1.Job{}
+	}
+
+	// The trace context is given only when the Job is created, so that the sync appears in the trace
+	// of the reconcile creating the Job, and is kept afterwards since the pod template is immutable.
+	job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, traceContextEnv(ctx, current)...)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	if err != nil {
+		return err
+	}
+	patch := &unstructured.Unstructured{
+		Object: obj,
+	}
+
 	currApplyConfig, err := batchv1apply.ExtractJob(&current, "docserver-controller")
 	if err != nil {
 		return err
 	}
 
 	if equality.Semantic.DeepEqual(job, currApplyConfig) {
+		logger.V(logLevelDebug).Info("Job is up to date")
 		return nil
 	}
+	logApplyDiff(logger, currApplyConfig, job)
 
 	err = r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
 		FieldManager: "docserver-controller",
@@ -288,54 +504,106 @@ func (r *DocServerReconciler) reconcileJob(ctx context.Context, ds updatev1beta1
 		logger.Error(err, "unable to create or update Job")
 		return err
 	}
-	logger.Info("reconcile Job successfully", "name", ds.Name)
+	r.recordApplied(&ds, &current, "Job", jobName)
+	if len(current.UID) == 0 {
+		r.Recorder.Eventf(&ds, corev1.EventTypeNormal, reasonSyncStarted, "Started syncing branch %s with Job %s", branch, jobName)
+		logger.Info("start syncing", "branch", branch, "commit", ds.Status.Commit)
+	}
+	logger.Info("reconcile Job successfully")
 	return nil
 }
 
-func (r *DocServerReconciler) reconcilePersistenVolumeClaim(ctx context.Context, ds updatev1beta1.DocServer) error {
-	logger := log.FromContext(ctx)
+func (r *DocServerReconciler) reconcilePersistenVolumeClaim(ctx context.Context, ds updatev1.DocServer, class *updatev1.DocServerClass) (err error) {
+	ctx, span := startSpan(ctx, "reconcilePersistenVolumeClaim", ds)
+	defer func() { endSpan(span, err) }()
+
+	if ds.Spec.Storage.IsExternal() {
+		return nil
+	}
 
 	pvcName := "docserver-" + ds.Name
+	logger := childLogger(ctx, "PersistentVolumeClaim", pvcName)
 
-	size := "3Gi"
+	size := class.Spec.Storage.Size
 	if len(ds.Spec.Storage.Size) != 0 {
 		size = ds.Spec.Storage.Size
 	}
 
-	storageClassName := "default"
+	storageClassName := class.Spec.Storage.StorageClass
 	if len(ds.Spec.Storage.StorageClass) != 0 {
 		storageClassName = ds.Spec.Storage.StorageClass
 	}
 
-	accessmode := corev1.ReadWriteMany
+	var current corev1.PersistentVolumeClaim
+	err = r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: pvcName}, &current)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	modes := accessModes(ds)
+	mode := volumeMode(ds)
+	selector := ds.Spec.Storage.Selector
+	if err == nil {
+		storageClassName, size, err = r.pvcChanges(ctx, &current, storageClassName, size)
+		if err != nil {
+			return err
+		}
+		if !equality.Semantic.DeepEqual(current.Spec.AccessModes, modes) || !equality.Semantic.DeepEqual(current.Spec.Selector, selector) {
+			logger.Info("access modes and selector of PersistentVolumeClaim are immutable, keep the current ones")
+			modes = current.Spec.AccessModes
+			selector = current.Spec.Selector
+		}
+		if current.Spec.VolumeMode != nil && *current.Spec.VolumeMode != mode {
+			logger.Info("volume mode of PersistentVolumeClaim is immutable, keep the current one")
+			mode = *current.Spec.VolumeMode
+		}
+	}
 
 	owner, err := controllerReference(ds, r.Scheme)
 	if err != nil {
 		return err
 	}
-	blockOwnerDeletion := false
-	owner.BlockOwnerDeletion = &blockOwnerDeletion
-	if ds.Spec.Storage.BlockOwnerDeletion != nil {
-		owner.BlockOwnerDeletion = ds.Spec.Storage.BlockOwnerDeletion
+
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return fmt.Errorf("invalid storage size %q: %w", size, err)
 	}
 
 	pvc := corev1apply.PersistentVolumeClaim(pvcName, ds.Namespace).
+		WithLabels(ds.Spec.Storage.Labels).
 		WithLabels(map[string]string{
 			"app.kubernetes.io/name":       "mkdocs",
 			"app.kubernetes.io/instance":   ds.Name,
 			"app.kubernetes.io/created-by": "docserver-controller",
 		}).
-		WithOwnerReferences(owner).
 		WithSpec(corev1apply.PersistentVolumeClaimSpec().
 			WithResources(corev1apply.ResourceRequirements().
 				WithRequests(corev1.ResourceList{
-					corev1.ResourceStorage: resource.MustParse(size),
+					corev1.ResourceStorage: quantity,
 				}),
 			).
-			WithAccessModes(accessmode).
+			WithAccessModes(modes...).
 			WithStorageClassName(storageClassName).
-			WithVolumeMode(corev1.PersistentVolumeFilesystem),
+			WithVolumeMode(mode),
 		)
+	if len(ds.Spec.Storage.Annotations) != 0 {
+		pvc.WithAnnotations(ds.Spec.Storage.Annotations)
+	}
+	// The retained PersistentVolumeClaim has no owner reference, so that neither the foreground nor
+	// the cascading deletion of the DocServer lets the garbage collector delete it.
+	if ds.Spec.Storage.RetentionPolicy != updatev1.RetentionPolicyRetain {
+		pvc.WithOwnerReferences(owner)
+	}
+	if protectsPersistentVolumeClaim(ds) {
+		pvc.WithFinalizers(snapshotProtectionFinalizer)
+	}
+	if selector != nil {
+		pvcSelector := metav1apply.LabelSelector()
+		err = convertApplyConfiguration(selector, pvcSelector)
+		if err != nil {
+			return err
+		}
+		pvc.Spec.WithSelector(pvcSelector)
+	}
 
 	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pvc)
 	if err != nil {
@@ -345,20 +613,16 @@ func (r *DocServerReconciler) reconcilePersistenVolumeClaim(ctx context.Context,
 		Object: obj,
 	}
 
-	var current corev1.PersistentVolumeClaim
-	err = r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: pvcName}, &current)
-	if err != nil && !errors.IsNotFound(err) {
-		return err
-	}
-
 	currApplyConfig, err := corev1apply.ExtractPersistentVolumeClaim(&current, "docserver-controller")
 	if err != nil {
 		return err
 	}
 
 	if equality.Semantic.DeepEqual(pvc, currApplyConfig) {
+		logger.V(logLevelDebug).Info("PersistentVolumeClaim is up to date")
 		return nil
 	}
+	logApplyDiff(logger, currApplyConfig, pvc)
 
 	err = r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
 		FieldManager: "docserver-controller",
@@ -369,19 +633,25 @@ func (r *DocServerReconciler) reconcilePersistenVolumeClaim(ctx context.Context,
 		return err
 	}
 
-	logger.Info("reconcile PersistentVolumeClaim successfully", "name", ds.Name)
+	r.recordApplied(&ds, &current, "PersistentVolumeClaim", pvcName)
+	logger.Info("reconcile PersistentVolumeClaim successfully")
 	return nil
 }
 
-func (r *DocServerReconciler) reconcileDeployment(ctx context.Context, ds updatev1beta1.DocServer) error {
-	logger := log.FromContext(ctx)
+func (r *DocServerReconciler) reconcileDeployment(ctx context.Context, ds updatev1.DocServer, class *updatev1.DocServerClass) (err error) {
+	ctx, span := startSpan(ctx, "reconcileDeployment", ds)
+	defer func() { endSpan(span, err) }()
 
 	depName := "docserver-" + ds.Name
-	pvcName := "docserver-" + ds.Name
+	logger := childLogger(ctx, "Deployment", depName)
+	volume, err := sourceVolume(ds)
+	if err != nil {
+		return err
+	}
 
-	image := "squidfunk/mkdocs-material"
-	if len(ds.Spec.Image) != 0 {
-		image = ds.Spec.Image
+	image := class.Spec.Image
+	if len(ds.Spec.Server.Image) != 0 {
+		image = ds.Spec.Server.Image
 	}
 	owner, err := controllerReference(ds, r.Scheme)
 	if err != nil {
@@ -396,27 +666,14 @@ func (r *DocServerReconciler) reconcileDeployment(ctx context.Context, ds update
 		}).
 		WithOwnerReferences(owner).
 		WithSpec(appsv1apply.DeploymentSpec().
-			WithReplicas(ds.Spec.Replicas).
-			WithSelector(metav1apply.LabelSelector().WithMatchLabels(map[string]string{
-				"app.kubernetes.io/name":       "mkdocs",
-				"app.kubernetes.io/instance":   ds.Name,
-				"app.kubernetes.io/created-by": "docserver-controller",
-			})).
+			WithSelector(metav1apply.LabelSelector().WithMatchLabels(serverPodLabels(ds))).
 			WithTemplate(corev1apply.PodTemplateSpec().
-				WithLabels(map[string]string{
-					"app.kubernetes.io/name":       "mkdocs",
-					"app.kubernetes.io/instance":   ds.Name,
-					"app.kubernetes.io/created-by": "docserver-controller",
-				}).
+				WithLabels(serverPodLabels(ds)).
 				WithSpec(corev1apply.PodSpec().
 					WithContainers(corev1apply.Container().
 						WithName("mkdocs").
 						WithImage(image).
 						WithImagePullPolicy(corev1.PullIfNotPresent).
-						WithVolumeMounts(corev1apply.VolumeMount().
-							WithName("source").
-							WithMountPath("/docs"),
-						).
 						WithPorts(corev1apply.ContainerPort().
 							WithName("http").
 							WithProtocol(corev1.ProtocolTCP).
@@ -437,16 +694,38 @@ func (r *DocServerReconciler) reconcileDeployment(ctx context.Context, ds update
 							),
 						),
 					).
-					WithVolumes(corev1apply.Volume().
-						WithName("source").
-						WithPersistentVolumeClaim(corev1apply.PersistentVolumeClaimVolumeSource().
-							WithClaimName(pvcName),
-						),
-					),
+					WithVolumes(volume),
 				),
 			),
 		)
 
+	mountSourceVolume(&dep.Spec.Template.Spec.Containers[0], ds)
+
+	// The replicas is left to HorizontalPodAutoscaler if autoscaling is enabled.
+	if ds.Spec.Autoscaling == nil {
+		dep.Spec.WithReplicas(ds.Spec.Replicas)
+	}
+
+	applyPodOptions(dep.Spec.Template.Spec, ds)
+	err = applyPodTemplate(dep.Spec.Template, class.Spec.PodTemplate)
+	if err != nil {
+		return err
+	}
+	applyCoScheduling(dep.Spec.Template.Spec, ds)
+
+	if isReadWriteOncePod(ds) {
+		// The volume cannot be mounted by the docserver pods and the gitpod pod at the same time.
+		dep.Spec.WithStrategy(appsv1apply.DeploymentStrategy().WithType(appsv1.RecreateDeploymentStrategyType))
+		syncing, err := r.syncInProgress(ctx, ds)
+		if err != nil {
+			return err
+		}
+		if syncing {
+			logger.Info("stop docserver pods while gitpod pod runs")
+			dep.Spec.WithReplicas(0)
+		}
+	}
+
 	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dep)
 	if err != nil {
 		return err
@@ -461,14 +740,34 @@ func (r *DocServerReconciler) reconcileDeployment(ctx context.Context, ds update
 		return err
 	}
 
+	// The selector of a Deployment is immutable, so the Deployment created with the previous selector is
+	// deleted orphaning its ReplicaSets, which the recreated Deployment adopts without restarting the pods.
+	// The deletion triggers the reconcile again, which recreates the Deployment.
+	if err == nil && metav1.IsControlledBy(&current, &ds) && current.Spec.Selector != nil &&
+		!equality.Semantic.DeepEqual(current.Spec.Selector.MatchLabels, serverPodLabels(ds)) {
+		if !current.DeletionTimestamp.IsZero() {
+			logger.Info("wait for Deployment with the previous selector to be deleted")
+			return nil
+		}
+		err = r.Delete(ctx, &current, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable to delete Deployment")
+			return err
+		}
+		logger.Info("selector changed, recreate Deployment")
+		return nil
+	}
+
 	currApplyConfig, err := appsv1apply.ExtractDeployment(&current, "docserver-controller")
 	if err != nil {
 		return err
 	}
 
 	if equality.Semantic.DeepEqual(dep, currApplyConfig) {
+		logger.V(logLevelDebug).Info("Deployment is up to date")
 		return nil
 	}
+	logApplyDiff(logger, currApplyConfig, dep)
 
 	err = r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
 		FieldManager: "docserver-controller",
@@ -479,13 +778,17 @@ func (r *DocServerReconciler) reconcileDeployment(ctx context.Context, ds update
 		logger.Error(err, "unable to create or update Deployment")
 		return err
 	}
-	logger.Info("reconcile Deployment successfully", "name", ds.Name)
+	r.recordApplied(&ds, &current, "Deployment", depName)
+	logger.Info("reconcile Deployment successfully")
 	return nil
 }
 
-func (r *DocServerReconciler) reconcileService(ctx context.Context, ds updatev1beta1.DocServer) error {
-	logger := log.FromContext(ctx)
+func (r *DocServerReconciler) reconcileService(ctx context.Context, ds updatev1.DocServer) (err error) {
+	ctx, span := startSpan(ctx, "reconcileService", ds)
+	defer func() { endSpan(span, err) }()
+
 	svcName := "docserver-" + ds.Name
+	logger := childLogger(ctx, "Service", svcName)
 	owner, err := controllerReference(ds, r.Scheme)
 	if err != nil {
 		return err
@@ -499,11 +802,7 @@ func (r *DocServerReconciler) reconcileService(ctx context.Context, ds updatev1b
 		}).
 		WithOwnerReferences(owner).
 		WithSpec(corev1apply.ServiceSpec().
-			WithSelector(map[string]string{
-				"app.kubernetes.io/name":       "mkdocs",
-				"app.kubernetes.io/instance":   ds.Name,
-				"app.kubernetes.io/created-by": "docserver-controller",
-			}).
+			WithSelector(serverPodLabels(ds)).
 			WithType(corev1.ServiceTypeClusterIP).
 			WithPorts(corev1apply.ServicePort().
 				WithProtocol(corev1.ProtocolTCP).
@@ -532,8 +831,10 @@ func (r *DocServerReconciler) reconcileService(ctx context.Context, ds updatev1b
 	}
 
 	if equality.Semantic.DeepEqual(svc, currApplyConfig) {
+		logger.V(logLevelDebug).Info("Service is up to date")
 		return nil
 	}
+	logApplyDiff(logger, currApplyConfig, svc)
 
 	err = r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
 		FieldManager: "docserver-controller",
@@ -544,52 +845,305 @@ func (r *DocServerReconciler) reconcileService(ctx context.Context, ds updatev1b
 		return err
 	}
 
-	logger.Info("reconcile Service successfully", "name", ds.Name)
+	r.recordApplied(&ds, &current, "Service", svcName)
+	logger.Info("reconcile Service successfully")
+	return nil
+}
+
+func (r *DocServerReconciler) reconcileIngress(ctx context.Context, ds updatev1.DocServer, class *updatev1.DocServerClass) (err error) {
+	ctx, span := startSpan(ctx, "reconcileIngress", ds)
+	defer func() { endSpan(span, err) }()
+
+	ingName := "docserver-" + ds.Name
+	svcName := "docserver-" + ds.Name
+	logger := childLogger(ctx, "Ingress", ingName)
+
+	var current networkingv1.Ingress
+	err = r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: ingName}, &current)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if class.Spec.Ingress == nil {
+		if err != nil || !metav1.IsControlledBy(&current, &ds) {
+			return nil
+		}
+		err = r.Delete(ctx, &current)
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "unable to delete Ingress")
+			return err
+		}
+		logger.Info("delete Ingress successfully")
+		return nil
+	}
+
+	owner, err := controllerReference(ds, r.Scheme)
+	if err != nil {
+		return err
+	}
+
+	host := fmt.Sprintf("%s-%s.%s", ds.Name, ds.Namespace, class.Spec.Ingress.Domain)
+	ing := networkingv1apply.Ingress(ingName, ds.Namespace).
+		WithLabels(map[string]string{
+			"app.kubernetes.io/name":       "mkdocs",
+			"app.kubernetes.io/instance":   ds.Name,
+			"app.kubernetes.io/created-by": "docserver-controller",
+		}).
+		WithOwnerReferences(owner).
+		WithSpec(networkingv1apply.IngressSpec().
+			WithRules(networkingv1apply.IngressRule().
+				WithHost(host).
+				WithHTTP(networkingv1apply.HTTPIngressRuleValue().
+					WithPaths(networkingv1apply.HTTPIngressPath().
+						WithPath("/").
+						WithPathType(networkingv1.PathTypePrefix).
+						WithBackend(networkingv1apply.IngressBackend().
+							WithService(networkingv1apply.IngressServiceBackend().
+								WithName(svcName).
+								WithPort(networkingv1apply.ServiceBackendPort().
+									WithNumber(8000),
+								),
+							),
+						),
+					),
+				),
+			),
+		)
+	if len(class.Spec.Ingress.Annotations) != 0 {
+		ing.WithAnnotations(class.Spec.Ingress.Annotations)
+	}
+	if class.Spec.Ingress.IngressClassName != nil {
+		ing.Spec.WithIngressClassName(*class.Spec.Ingress.IngressClassName)
+	}
+	if len(class.Spec.Ingress.TLSSecret) != 0 {
+		ing.Spec.WithTLS(networkingv1apply.IngressTLS().
+			WithHosts(host).
+			WithSecretName(class.Spec.Ingress.TLSSecret),
+		)
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ing)
+	if err != nil {
+		return err
+	}
+	patch := &unstructured.Unstructured{
+		Object: obj,
+	}
+
+	currApplyConfig, err := networkingv1apply.ExtractIngress(&current, "docserver-controller")
+	if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(ing, currApplyConfig) {
+		logger.V(logLevelDebug).Info("Ingress is up to date")
+		return nil
+	}
+	logApplyDiff(logger, currApplyConfig, ing)
+
+	err = r.Patch(ctx, patch, client.Apply, &client.PatchOptions{
+		FieldManager: "docserver-controller",
+		Force:        pointer.Bool(true),
+	})
+	if err != nil {
+		logger.Error(err, "unable to create or update Ingress")
+		return err
+	}
+
+	r.recordApplied(&ds, &current, "Ingress", ingName)
+	logger.Info("reconcile Ingress successfully")
 	return nil
 }
 
-func (r *DocServerReconciler) updateStatus(ctx context.Context, ds updatev1beta1.DocServer) (ctrl.Result, error) {
+func (r *DocServerReconciler) updateStatus(ctx context.Context, ds updatev1.DocServer, commit string) (result ctrl.Result, err error) {
+	ctx, span := startSpan(ctx, "updateStatus", ds)
+	defer func() { endSpan(span, err) }()
+
+	logger := log.FromContext(ctx)
+
 	var dep appsv1.Deployment
-	err := r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: "docserver-" + ds.Name}, &dep)
+	err = r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: "docserver-" + ds.Name}, &dep)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	var status updatev1beta1.DocServerStatus
+	desired, err := r.desiredReplicas(ctx, ds, dep)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	docServerMetrics.observeReplicas(client.ObjectKeyFromObject(&ds), dep.Status.ReadyReplicas, desired)
+
+	var pods corev1.PodList
+	err = r.List(ctx, &pods, client.InNamespace(ds.Namespace), client.MatchingLabels(serverPodLabels(ds)))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	docServerMetrics.observePods(client.ObjectKeyFromObject(&ds), pods.Items)
+
+	status := ds.Status.DeepCopy()
+	ready := metav1.Condition{
+		Type:               readyCondition,
+		ObservedGeneration: ds.Generation,
+	}
 	if dep.Status.AvailableReplicas == 0 {
-		status = updatev1beta1.DocServerNotReady
-	} else if dep.Status.AvailableReplicas == ds.Spec.Replicas {
-		status = updatev1beta1.DocServerHealthy
+		status.Phase = updatev1.DocServerNotReady
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = "NoAvailableReplicas"
+		ready.Message = "No docserver pod is available."
+	} else if dep.Status.AvailableReplicas >= desired {
+		status.Phase = updatev1.DocServerHealthy
+		ready.Status = metav1.ConditionTrue
+		ready.Reason = "AllReplicasAvailable"
+		ready.Message = "All docserver pods are available."
 	} else {
-		status = updatev1beta1.DocServerAvailable
+		status.Phase = updatev1.DocServerAvailable
+		ready.Status = metav1.ConditionTrue
+		ready.Reason = "ReplicasAvailable"
+		ready.Message = fmt.Sprintf("%d of %d docserver pods are available.", dep.Status.AvailableReplicas, desired)
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+	status.ObservedGeneration = ds.Generation
+	if len(commit) != 0 && status.Commit != commit {
+		logger.Info("record synced commit", "commit", commit)
+		status.Commit = commit
 	}
 
-	if ds.Status != status {
-		ds.Status = status
+	if !equality.Semantic.DeepEqual(&ds.Status, status) {
+		previous := ds.Status
+		ds.Status = *status
 		err = r.Status().Update(ctx, &ds)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+		if previous.Commit != status.Commit {
+			r.Recorder.Eventf(&ds, corev1.EventTypeNormal, reasonSyncCompleted, "Synced commit %s", status.Commit)
+		}
+		r.recordPhase(&ds, previous.Phase, status.Phase, ready.Message)
 	}
 
-	if ds.Status != updatev1beta1.DocServerHealthy {
-		return ctrl.Result{Requeue: true}, nil
+	if ds.Status.Phase != updatev1.DocServerHealthy {
+		return r.requeueAfter(client.ObjectKeyFromObject(&ds), ready.Reason), nil
 	}
+	r.resetRequeue(client.ObjectKeyFromObject(&ds))
 	return ctrl.Result{}, nil
 }
 
+// syncedCommit returns the commit synced by the gitpod Job, or an empty string if the Job has not succeeded.
+// The commit is read from the termination message of the gitpod container.
+// The metrics of the Job are recorded as well.
+func (r *DocServerReconciler) syncedCommit(ctx context.Context, ds updatev1.DocServer) (string, error) {
+	jobName := "gitpod-" + ds.Name
+
+	var job batchv1.Job
+	err := r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: jobName}, &job)
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	docServerMetrics.observeJob(client.ObjectKeyFromObject(&ds), &job)
+	if job.Status.Succeeded == 0 {
+		return "", nil
+	}
+
+	var pods corev1.PodList
+	err = r.List(ctx, &pods, client.InNamespace(ds.Namespace), client.MatchingLabels{"job-name": jobName})
+	if err != nil {
+		return "", err
+	}
+
+	var commit string
+	var committed time.Time
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name == "gitpod" && cs.State.Terminated != nil {
+				commit, committed = parseTerminationMessage(cs.State.Terminated.Message)
+			}
+		}
+	}
+	if len(commit) != 0 {
+		docServerMetrics.observeCommit(client.ObjectKeyFromObject(&ds), commit, committed)
+	}
+	return commit, nil
+}
+
+// WatchedObjects returns the kinds of the objects that the controller reads from the cache.
+func WatchedObjects() []client.Object {
+	return []client.Object{
+		&updatev1.DocServer{},
+		&updatev1.DocServerClass{},
+		&corev1.PersistentVolumeClaim{},
+		&batchv1.Job{},
+		&appsv1.Deployment{},
+		&corev1.Service{},
+		&networkingv1.Ingress{},
+		&autoscalingv2.HorizontalPodAutoscaler{},
+		&policyv1.PodDisruptionBudget{},
+		&corev1.Secret{},
+		&corev1.ConfigMap{},
+		&corev1.Pod{},
+		&storagev1.StorageClass{},
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *DocServerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.requeueBackoff = newRequeueBackoff(r.MaxRequeueInterval)
+
+	ctx := context.Background()
+	err := mgr.GetFieldIndexer().IndexField(ctx, &updatev1.DocServer{}, secretRefIndexKey, indexSecretRefs)
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(ctx, &updatev1.DocServer{}, configMapRefIndexKey, indexConfigMapRefs)
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(ctx, &updatev1.DocServer{}, classNameIndexKey, indexClassName)
+	if err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&updatev1beta1.DocServer{}).
+		For(&updatev1.DocServer{}).
 		Owns(&corev1.PersistentVolumeClaim{}).
 		Owns(&batchv1.Job{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
+		Owns(&networkingv1.Ingress{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.findDocServersForSecret),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.findDocServersForConfigMap),
+		).
+		Watches(
+			&source.Kind{Type: &updatev1.DocServerClass{}},
+			handler.EnqueueRequestsFromMapFunc(r.findDocServersForClass),
+		).
 		Complete(r)
 }
 
-func controllerReference(ds updatev1beta1.DocServer, scheme *runtime.Scheme) (*metav1apply.OwnerReferenceApplyConfiguration, error) {
+// applyPodOptions applies the options common to the docserver and gitpod pods.
+func applyPodOptions(spec *corev1apply.PodSpecApplyConfiguration, ds updatev1.DocServer) {
+	for _, secret := range ds.Spec.ImagePullSecrets {
+		spec.WithImagePullSecrets(corev1apply.LocalObjectReference().WithName(secret.Name))
+	}
+	if len(ds.Spec.ServiceAccountName) != 0 {
+		spec.WithServiceAccountName(ds.Spec.ServiceAccountName)
+	}
+}
+
+func controllerReference(ds updatev1.DocServer, scheme *runtime.Scheme) (*metav1apply.OwnerReferenceApplyConfiguration, error) {
 	gvk, err := apiutil.GVKForObject(&ds, scheme)
 	if err != nil {
 		return nil, err
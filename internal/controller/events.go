@@ -0,0 +1,78 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The reasons of the events recorded on the DocServer.
+const (
+	// reasonSyncStarted is recorded when the gitpod Job is created to sync the sources.
+	reasonSyncStarted = "SyncStarted"
+	// reasonSyncCompleted is recorded when the gitpod Job has synced a new commit.
+	reasonSyncCompleted = "SyncCompleted"
+	// reasonCreated and reasonUpdated are recorded when a child resource is applied.
+	reasonCreated = "Created"
+	reasonUpdated = "Updated"
+	// reasonSpecRejected is recorded when the DocServer does not pass the validation, e.g. it was created while the webhook was disabled.
+	reasonSpecRejected = "SpecRejected"
+	// reasonReconcileFailed is recorded when the reconcile returns an error.
+	reasonReconcileFailed = "ReconcileFailed"
+	// reasonSnapshotFailed is recorded when the VolumeSnapshot of the Snapshot retention policy fails or cannot be created.
+	reasonSnapshotFailed = "SnapshotFailed"
+)
+
+// recordApplied records the event of the child resource created or updated by the apply patch.
+// The child resource is created if the current one has not been found.
+func (r *DocServerReconciler) recordApplied(ds *updatev1.DocServer, current metav1.Object, kind, name string) {
+	if len(current.GetUID()) == 0 {
+		r.Recorder.Eventf(ds, corev1.EventTypeNormal, reasonCreated, "Created %s %s", kind, name)
+		return
+	}
+	r.Recorder.Eventf(ds, corev1.EventTypeNormal, reasonUpdated, "Updated %s %s", kind, name)
+}
+
+// recordPhase records the event of the transition of the phase, whose reason is the new phase.
+func (r *DocServerReconciler) recordPhase(ds *updatev1.DocServer, from, to updatev1.DocServerPhase, message string) {
+	if from == to {
+		return
+	}
+	eventType := corev1.EventTypeNormal
+	if to == updatev1.DocServerNotReady {
+		eventType = corev1.EventTypeWarning
+	}
+	if len(from) == 0 {
+		r.Recorder.Event(ds, eventType, string(to), message)
+		return
+	}
+	r.Recorder.Event(ds, eventType, string(to), fmt.Sprintf("Phase changed from %s. %s", from, message))
+}
+
+// recordReconcileError records the event of the error returned by the reconcile.
+// The conflicts are resolved by the retry, so they are not worth an event.
+func (r *DocServerReconciler) recordReconcileError(ds *updatev1.DocServer, err error) {
+	if err == nil || errors.IsConflict(err) {
+		return
+	}
+	r.Recorder.Event(ds, corev1.EventTypeWarning, reasonReconcileFailed, err.Error())
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+)
+
+// recordedEvents drains the events recorded so far.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestRecordApplied(t *testing.T) {
+	r, recorder := newFakeReconciler(t)
+	ds := &updatev1.DocServer{ObjectMeta: metav1.ObjectMeta{Name: "docs", Namespace: "default"}}
+
+	r.recordApplied(ds, &corev1.Service{}, "Service", "docserver-docs")
+	r.recordApplied(ds, &corev1.Service{ObjectMeta: metav1.ObjectMeta{UID: "uid"}}, "Service", "docserver-docs")
+
+	actual := recordedEvents(recorder)
+	expected := []string{
+		"Normal Created Created Service docserver-docs",
+		"Normal Updated Updated Service docserver-docs",
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("recorded %q, want %q", actual, expected)
+	}
+}
+
+func TestRecordPhase(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     updatev1.DocServerPhase
+		to       updatev1.DocServerPhase
+		expected []string
+	}{
+		{"unchanged", updatev1.DocServerHealthy, updatev1.DocServerHealthy, nil},
+		{"first phase", "", updatev1.DocServerAvailable, []string{"Normal Available message"}},
+		{"healthy", updatev1.DocServerAvailable, updatev1.DocServerHealthy, []string{"Normal Healthy Phase changed from Available. message"}},
+		{"not ready", updatev1.DocServerHealthy, updatev1.DocServerNotReady, []string{"Warning NotReady Phase changed from Healthy. message"}},
+		{"first phase not ready", "", updatev1.DocServerNotReady, []string{"Warning NotReady message"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, recorder := newFakeReconciler(t)
+			ds := &updatev1.DocServer{ObjectMeta: metav1.ObjectMeta{Name: "docs", Namespace: "default"}}
+
+			r.recordPhase(ds, tt.from, tt.to, "message")
+
+			if actual := recordedEvents(recorder); fmt.Sprint(actual) != fmt.Sprint(tt.expected) {
+				t.Errorf("recorded %q, want %q", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRecordReconcileError(t *testing.T) {
+	conflict := apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "docserver-docs", errors.New("the object has been modified"))
+
+	tests := []struct {
+		name     string
+		err      error
+		expected []string
+	}{
+		{"no error", nil, nil},
+		{"error", errors.New("unable to apply"), []string{"Warning ReconcileFailed unable to apply"}},
+		{"conflict", conflict, nil},
+		{"wrapped conflict", fmt.Errorf("unable to update status: %w", conflict), nil},
+		{"not found", apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "ssh-key"), []string{`Warning ReconcileFailed secrets "ssh-key" not found`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, recorder := newFakeReconciler(t)
+			ds := &updatev1.DocServer{ObjectMeta: metav1.ObjectMeta{Name: "docs", Namespace: "default"}}
+
+			r.recordReconcileError(ds, tt.err)
+
+			if actual := recordedEvents(recorder); fmt.Sprint(actual) != fmt.Sprint(tt.expected) {
+				t.Errorf("recorded %q, want %q", actual, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	"github.com/git-ogawa/docserver/internal/features"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// finalizerName is the finalizer that keeps the DocServer until the PersistentVolumeClaim is handled
+	// according to the retention policy.
+	finalizerName = "docserver.git-ogawa.github.io/finalizer"
+
+	// snapshotProtectionFinalizer is the finalizer of the PersistentVolumeClaim with the Snapshot retention policy,
+	// which keeps the PersistentVolumeClaim from being deleted, e.g. by the foreground deletion of the DocServer,
+	// until the VolumeSnapshot is ready to use.
+	snapshotProtectionFinalizer = "docserver.git-ogawa.github.io/snapshot-protection"
+
+	// retainedLabel is the label of the PersistentVolumeClaim retained after the DocServer is deleted,
+	// whose value is the name of the DocServer.
+	retainedLabel = "docserver.git-ogawa.github.io/retained-from"
+)
+
+var volumeSnapshotGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshot"}
+
+// finalize handles the PersistentVolumeClaim of the deleted DocServer according to the retention policy,
+// and then removes the finalizer. The other child resources are deleted by the garbage collector.
+func (r *DocServerReconciler) finalize(ctx context.Context, ds updatev1.DocServer) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(&ds, finalizerName) {
+		return ctrl.Result{}, nil
+	}
+
+	var pvc corev1.PersistentVolumeClaim
+	err := r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: "docserver-" + ds.Name}, &pvc)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if err == nil && ownsPersistentVolumeClaim(&pvc, ds) {
+		switch ds.Spec.Storage.RetentionPolicy {
+		case updatev1.RetentionPolicyRetain:
+			err = r.retainPersistentVolumeClaim(ctx, ds, &pvc)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		case updatev1.RetentionPolicySnapshot:
+			if !features.Enabled(features.SnapshotRetention) {
+				logger.Info("SnapshotRetention feature is disabled, delete PersistentVolumeClaim without snapshot")
+				break
+			}
+			ready, err := r.snapshotPersistentVolumeClaim(ctx, ds, &pvc)
+			if meta.IsNoMatchError(err) {
+				// The snapshot is never created without the CRD, so the PersistentVolumeClaim is retained
+				// instead of blocking the deletion forever or losing the data.
+				r.Recorder.Eventf(&ds, corev1.EventTypeWarning, reasonSnapshotFailed,
+					"VolumeSnapshot is not available in the cluster, retained PersistentVolumeClaim %s instead", pvc.Name)
+				err = r.retainPersistentVolumeClaim(ctx, ds, &pvc)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+				break
+			}
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if !ready {
+				return r.requeueAfter(client.ObjectKeyFromObject(&ds), "SnapshotNotReady"), nil
+			}
+		}
+	}
+	if err == nil {
+		err = r.releasePersistentVolumeClaim(ctx, &pvc)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	patch := client.MergeFrom(ds.DeepCopy())
+	controllerutil.RemoveFinalizer(&ds, finalizerName)
+	err = r.Patch(ctx, &ds, patch)
+	if err != nil {
+		logger.Error(err, "unable to remove finalizer")
+		return ctrl.Result{}, err
+	}
+	docServerMetrics.forget(client.ObjectKeyFromObject(&ds))
+	r.resetRequeue(client.ObjectKeyFromObject(&ds))
+
+	logger.Info("finalize DocServer successfully", "retentionPolicy", ds.Spec.Storage.RetentionPolicy)
+	return ctrl.Result{}, nil
+}
+
+// retainPersistentVolumeClaim orphans the PersistentVolumeClaim so that it is not deleted by the garbage collector.
+// The PersistentVolumeClaim is labeled with the name of the DocServer, and is adopted again by the DocServer created with the same name.
+func (r *DocServerReconciler) retainPersistentVolumeClaim(ctx context.Context, ds updatev1.DocServer, pvc *corev1.PersistentVolumeClaim) error {
+	logger := childLogger(ctx, "PersistentVolumeClaim", pvc.Name)
+
+	patch := client.MergeFrom(pvc.DeepCopy())
+	var owners []metav1.OwnerReference
+	for _, owner := range pvc.OwnerReferences {
+		if owner.UID != ds.UID {
+			owners = append(owners, owner)
+		}
+	}
+	pvc.OwnerReferences = owners
+	if pvc.Labels == nil {
+		pvc.Labels = map[string]string{}
+	}
+	pvc.Labels[retainedLabel] = ds.Name
+
+	err := r.Patch(ctx, pvc, patch)
+	if err != nil {
+		logger.Error(err, "unable to retain PersistentVolumeClaim")
+		return err
+	}
+	logger.Info("retain PersistentVolumeClaim")
+	return nil
+}
+
+// snapshotPersistentVolumeClaim creates the VolumeSnapshot of the PersistentVolumeClaim,
+// and returns whether or not the snapshot is ready to use. The PersistentVolumeClaim must not be deleted until then.
+func (r *DocServerReconciler) snapshotPersistentVolumeClaim(ctx context.Context, ds updatev1.DocServer, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	// The name is fixed by the deletion timestamp so that the following reconciles find the same snapshot.
+	name := fmt.Sprintf("%s-%d", pvc.Name, ds.DeletionTimestamp.Unix())
+	logger := childLogger(ctx, "VolumeSnapshot", name)
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	err := r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: name}, snapshot)
+	if errors.IsNotFound(err) {
+		snapshot.SetName(name)
+		snapshot.SetNamespace(ds.Namespace)
+		snapshot.SetLabels(map[string]string{
+			"app.kubernetes.io/name":       "mkdocs",
+			"app.kubernetes.io/instance":   ds.Name,
+			"app.kubernetes.io/created-by": "docserver-controller",
+		})
+		spec := map[string]interface{}{
+			"source": map[string]interface{}{
+				"persistentVolumeClaimName": pvc.Name,
+			},
+		}
+		if len(ds.Spec.Storage.SnapshotClassName) != 0 {
+			spec["volumeSnapshotClassName"] = ds.Spec.Storage.SnapshotClassName
+		}
+		snapshot.Object["spec"] = spec
+
+		err = r.Create(ctx, snapshot)
+		if err != nil {
+			logger.Error(err, "unable to create VolumeSnapshot")
+			return false, err
+		}
+		logger.Info("create VolumeSnapshot", "persistentVolumeClaim", pvc.Name)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	ready, _, err := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	if err != nil {
+		return false, err
+	}
+	// The snapshot controller retries the failed snapshot, so the error is reported while waiting for it.
+	if message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); found && !ready {
+		logger.Info("VolumeSnapshot is failing", "message", message)
+		r.Recorder.Eventf(&ds, corev1.EventTypeWarning, reasonSnapshotFailed, "VolumeSnapshot %s is failing: %s", name, message)
+	}
+	return ready, nil
+}
+
+// releasePersistentVolumeClaim removes the snapshot protection finalizer from the PersistentVolumeClaim.
+func (r *DocServerReconciler) releasePersistentVolumeClaim(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	if !controllerutil.ContainsFinalizer(pvc, snapshotProtectionFinalizer) {
+		return nil
+	}
+	logger := childLogger(ctx, "PersistentVolumeClaim", pvc.Name)
+
+	patch := client.MergeFrom(pvc.DeepCopy())
+	controllerutil.RemoveFinalizer(pvc, snapshotProtectionFinalizer)
+	err := r.Patch(ctx, pvc, patch)
+	if err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "unable to remove snapshot protection finalizer")
+		return err
+	}
+	logger.Info("release PersistentVolumeClaim")
+	return nil
+}
+
+// protectsPersistentVolumeClaim returns whether or not the PersistentVolumeClaim has to be kept until its snapshot is ready.
+func protectsPersistentVolumeClaim(ds updatev1.DocServer) bool {
+	return ds.Spec.Storage.RetentionPolicy == updatev1.RetentionPolicySnapshot && features.Enabled(features.SnapshotRetention)
+}
+
+// ownsPersistentVolumeClaim returns whether or not the PersistentVolumeClaim belongs to the DocServer.
+// The PersistentVolumeClaim with the Retain policy has no owner reference, so it is identified by its labels.
+func ownsPersistentVolumeClaim(pvc *corev1.PersistentVolumeClaim, ds updatev1.DocServer) bool {
+	if metav1.IsControlledBy(pvc, &ds) {
+		return true
+	}
+	return ds.Spec.Storage.RetentionPolicy == updatev1.RetentionPolicyRetain &&
+		len(pvc.OwnerReferences) == 0 &&
+		pvc.Labels["app.kubernetes.io/instance"] == ds.Name &&
+		pvc.Labels["app.kubernetes.io/created-by"] == "docserver-controller"
+}
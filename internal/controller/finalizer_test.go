@@ -0,0 +1,210 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	"github.com/git-ogawa/docserver/internal/features"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("DocServer finalizer", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	newDocServer := func(name string, policy updatev1.RetentionPolicy) *updatev1.DocServer {
+		return &updatev1.DocServer{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Spec: updatev1.DocServerSpec{
+				Source: updatev1.Source{Git: updatev1.GitSource{
+					URL:    "https://github.com/git-ogawa/docserver.git",
+					Branch: "main",
+					Depth:  1,
+				}},
+				Storage: updatev1.Storage{RetentionPolicy: policy},
+			},
+		}
+	}
+
+	// pvcOf waits for the PersistentVolumeClaim of the DocServer to be created.
+	pvcOf := func(ds *updatev1.DocServer) *corev1.PersistentVolumeClaim {
+		var pvc corev1.PersistentVolumeClaim
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}, &pvc)
+		}, timeout, interval).Should(Succeed())
+		return &pvc
+	}
+
+	It("does not protect the PersistentVolumeClaim with the Delete retention policy", func() {
+		ds := newDocServer("delete-policy", updatev1.RetentionPolicyDelete)
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		pvc := pvcOf(ds)
+		Expect(pvc.Finalizers).NotTo(ContainElement(snapshotProtectionFinalizer))
+		Expect(pvc.OwnerReferences).To(HaveLen(1))
+	})
+
+	It("retains the PersistentVolumeClaim on the foreground deletion with the Retain retention policy", func() {
+		ds := newDocServer("retain-policy", updatev1.RetentionPolicyRetain)
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		// The garbage collector deletes the dependents before the owner on the foreground deletion,
+		// so the retained PersistentVolumeClaim must not have the owner reference at all.
+		pvc := pvcOf(ds)
+		Expect(pvc.OwnerReferences).To(BeEmpty())
+
+		Expect(k8sClient.Delete(ctx, ds, client.PropagationPolicy(metav1.DeletePropagationForeground))).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pvc), pvc)).To(Succeed())
+			g.Expect(pvc.Labels).To(HaveKeyWithValue(retainedLabel, ds.Name))
+		}, timeout, interval).Should(Succeed())
+		Expect(pvc.DeletionTimestamp).To(BeNil())
+		Expect(pvc.OwnerReferences).To(BeEmpty())
+
+		// The DocServer waits for the garbage collector, which envtest does not run, with its own finalizer removed.
+		Eventually(func(g Gomega) {
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(ds), ds)
+			if errors.IsNotFound(err) {
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(ds.Finalizers).NotTo(ContainElement(finalizerName))
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("retains the PersistentVolumeClaim when VolumeSnapshot is not available", func() {
+		ds := newDocServer("snapshot-policy", updatev1.RetentionPolicySnapshot)
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		// The finalizer keeps the PersistentVolumeClaim from being deleted by the foreground deletion until the snapshot is ready.
+		pvc := pvcOf(ds)
+		Expect(pvc.Finalizers).To(ContainElement(snapshotProtectionFinalizer))
+
+		Expect(k8sClient.Delete(ctx, ds)).To(Succeed())
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(ds), ds))
+		}, timeout, interval).Should(BeTrue())
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pvc), pvc)).To(Succeed())
+		Expect(pvc.Finalizers).NotTo(ContainElement(snapshotProtectionFinalizer))
+		Expect(pvc.OwnerReferences).To(BeEmpty())
+		Expect(pvc.Labels).To(HaveKeyWithValue(retainedLabel, ds.Name))
+
+		Eventually(func(g Gomega) {
+			g.Expect(eventReasons(g, ds)).To(ContainElement(reasonSnapshotFailed))
+		}, timeout, interval).Should(Succeed())
+	})
+})
+
+func TestProtectsPersistentVolumeClaim(t *testing.T) {
+	tests := []struct {
+		policy   updatev1.RetentionPolicy
+		enabled  bool
+		expected bool
+	}{
+		{policy: updatev1.RetentionPolicyDelete, enabled: true, expected: false},
+		{policy: updatev1.RetentionPolicyRetain, enabled: true, expected: false},
+		{policy: updatev1.RetentionPolicySnapshot, enabled: true, expected: true},
+		{policy: updatev1.RetentionPolicySnapshot, enabled: false, expected: false},
+	}
+	previous := features.Enabled(features.SnapshotRetention)
+	t.Cleanup(func() {
+		_ = features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{string(features.SnapshotRetention): previous})
+	})
+	for _, tt := range tests {
+		if err := features.DefaultMutableFeatureGate.SetFromMap(map[string]bool{string(features.SnapshotRetention): tt.enabled}); err != nil {
+			t.Fatal(err)
+		}
+		ds := updatev1.DocServer{Spec: updatev1.DocServerSpec{Storage: updatev1.Storage{RetentionPolicy: tt.policy}}}
+		if actual := protectsPersistentVolumeClaim(ds); actual != tt.expected {
+			t.Errorf("protectsPersistentVolumeClaim(%s, enabled=%t) = %t, want %t", tt.policy, tt.enabled, actual, tt.expected)
+		}
+	}
+}
+
+func TestOwnsPersistentVolumeClaim(t *testing.T) {
+	controller := true
+	labels := map[string]string{
+		"app.kubernetes.io/instance":   "docs",
+		"app.kubernetes.io/created-by": "docserver-controller",
+	}
+	tests := []struct {
+		name     string
+		policy   updatev1.RetentionPolicy
+		pvc      corev1.PersistentVolumeClaim
+		expected bool
+	}{
+		{
+			name:   "controlled",
+			policy: updatev1.RetentionPolicyDelete,
+			pvc: corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{{UID: "uid", Controller: &controller}},
+			}},
+			expected: true,
+		},
+		{
+			name:     "retained by labels",
+			policy:   updatev1.RetentionPolicyRetain,
+			pvc:      corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+			expected: true,
+		},
+		{
+			name:     "labeled without the Retain policy",
+			policy:   updatev1.RetentionPolicyDelete,
+			pvc:      corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+			expected: false,
+		},
+		{
+			name:   "controlled by another owner",
+			policy: updatev1.RetentionPolicyRetain,
+			pvc: corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				Labels:          labels,
+				OwnerReferences: []metav1.OwnerReference{{UID: "other", Controller: &controller}},
+			}},
+			expected: false,
+		},
+		{
+			name:     "not labeled",
+			policy:   updatev1.RetentionPolicyRetain,
+			pvc:      corev1.PersistentVolumeClaim{},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := updatev1.DocServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "docs", UID: "uid"},
+				Spec:       updatev1.DocServerSpec{Storage: updatev1.Storage{RetentionPolicy: tt.policy}},
+			}
+			if actual := ownsPersistentVolumeClaim(&tt.pvc, ds); actual != tt.expected {
+				t.Errorf("ownsPersistentVolumeClaim() = %t, want %t", actual, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+)
+
+// The verbosity levels of the logs, enabled by --zap-log-level.
+const (
+	// logLevelDebug logs the child resources that are already up to date.
+	logLevelDebug = 1
+	// logLevelDiff logs the differences applied to the child resources.
+	logLevelDiff = 2
+)
+
+// withReconcileLogger returns the context whose logger has the generation of the DocServer and the trace ID.
+// The logger from controller-runtime already has the namespace, the name and the reconcileID.
+func withReconcileLogger(ctx context.Context, ds updatev1.DocServer) context.Context {
+	logger := log.FromContext(ctx).WithValues("generation", ds.Generation)
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		logger = logger.WithValues("traceID", sc.TraceID().String())
+	}
+	return log.IntoContext(ctx, logger)
+}
+
+// childLogger returns the logger of the child resource of the DocServer.
+func childLogger(ctx context.Context, kind, name string) logr.Logger {
+	return log.FromContext(ctx).WithValues("childKind", kind, "childName", name)
+}
+
+// logApplyDiff logs the difference between the current and the desired apply configurations of the child resource.
+func logApplyDiff(logger logr.Logger, current, desired interface{}) {
+	if !logger.V(logLevelDiff).Enabled() {
+		return
+	}
+	currentObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+	if err != nil {
+		logger.V(logLevelDiff).Info("unable to compute apply diff", "error", err.Error())
+		return
+	}
+	desiredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		logger.V(logLevelDiff).Info("unable to compute apply diff", "error", err.Error())
+		return
+	}
+	logger.V(logLevelDiff).Info("apply diff", "diff", cmp.Diff(currentObj, desiredObj))
+}
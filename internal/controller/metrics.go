@@ -0,0 +1,271 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	syncDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "docserver_sync_duration_seconds",
+			Help:    "Duration of the successful syncs of the sources by the gitpod Job.",
+			Buckets: []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		},
+		[]string{"namespace", "name"},
+	)
+	lastSuccessfulSync = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docserver_last_successful_sync_timestamp_seconds",
+			Help: "Unix time when the sources were last synced successfully.",
+		},
+		[]string{"namespace", "name"},
+	)
+	syncFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "docserver_sync_failures_total",
+			Help: "Total number of the failed gitpod pods.",
+		},
+		[]string{"namespace", "name"},
+	)
+	readyReplicas = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docserver_ready_replicas",
+			Help: "Number of the ready docserver pods.",
+		},
+		[]string{"namespace", "name"},
+	)
+	desiredReplicasGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "docserver_desired_replicas",
+			Help: "Number of the desired docserver pods.",
+		},
+		[]string{"namespace", "name"},
+	)
+	buildDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "docserver_build_duration_seconds",
+			Help:    "Duration from the start of a docserver pod until it becomes ready, which is spent mostly on building the documents.",
+			Buckets: []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		},
+		[]string{"namespace", "name"},
+	)
+	leader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "docserver_controller_leader",
+			Help: "1 if the controller is the leader reconciling the DocServers, 0 if it is a standby replica.",
+		},
+	)
+	servedCommitAge = prometheus.NewDesc(
+		"docserver_served_commit_age_seconds",
+		"Seconds elapsed since the committer date of the served commit.",
+		[]string{"namespace", "name", "commit"},
+		nil,
+	)
+
+	docServerMetrics = newMetricsRecorder()
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		syncDuration,
+		lastSuccessfulSync,
+		syncFailures,
+		readyReplicas,
+		desiredReplicasGauge,
+		buildDuration,
+		leader,
+		docServerMetrics,
+	)
+}
+
+// RecordElected records that the controller has become the leader, or has started without the leader election.
+func RecordElected() {
+	leader.Set(1)
+}
+
+// metricsRecorder records the metrics of each DocServer. It remembers the Jobs and pods already observed
+// so that the durations and failures are counted only once across the reconciles.
+// The Jobs and pods are observed again after the manager restarts, so the durations and failures that
+// happened before the recorder started are left to the previous process.
+// It also collects the served commit age, which is computed at the time of scraping.
+type metricsRecorder struct {
+	mu      sync.Mutex
+	started time.Time
+	states  map[types.NamespacedName]*metricsState
+}
+
+type metricsState struct {
+	jobUID     types.UID
+	jobFailed  int32
+	jobSynced  bool
+	pods       map[types.UID]bool
+	commit     string
+	commitTime time.Time
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{started: time.Now(), states: map[types.NamespacedName]*metricsState{}}
+}
+
+func (m *metricsRecorder) state(key types.NamespacedName) *metricsState {
+	s, ok := m.states[key]
+	if !ok {
+		s = &metricsState{pods: map[types.UID]bool{}}
+		m.states[key] = s
+	}
+	return s
+}
+
+// observeJob records the failures and the duration of the gitpod Job.
+func (m *metricsRecorder) observeJob(key types.NamespacedName, job *batchv1.Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.state(key)
+	if s.jobUID != job.UID {
+		s.jobUID = job.UID
+		s.jobFailed = 0
+		s.jobSynced = false
+		// The failures of the Job created before the restart may have been counted by the previous process.
+		if job.CreationTimestamp.Time.Before(m.started) {
+			s.jobFailed = job.Status.Failed
+		}
+	}
+
+	if job.Status.Failed > s.jobFailed {
+		syncFailures.WithLabelValues(key.Namespace, key.Name).Add(float64(job.Status.Failed - s.jobFailed))
+		s.jobFailed = job.Status.Failed
+	}
+
+	if !s.jobSynced && job.Status.Succeeded > 0 && job.Status.StartTime != nil && job.Status.CompletionTime != nil {
+		s.jobSynced = true
+		if !job.Status.CompletionTime.Time.Before(m.started) {
+			syncDuration.WithLabelValues(key.Namespace, key.Name).
+				Observe(job.Status.CompletionTime.Sub(job.Status.StartTime.Time).Seconds())
+		}
+		lastSuccessfulSync.WithLabelValues(key.Namespace, key.Name).
+			Set(float64(job.Status.CompletionTime.Unix()))
+	}
+}
+
+// observeCommit records the served commit and its committer date.
+func (m *metricsRecorder) observeCommit(key types.NamespacedName, commit string, committed time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.state(key)
+	s.commit = commit
+	s.commitTime = committed
+}
+
+// observeReplicas records the ready and desired replicas of the docserver Deployment.
+func (m *metricsRecorder) observeReplicas(key types.NamespacedName, ready, desired int32) {
+	readyReplicas.WithLabelValues(key.Namespace, key.Name).Set(float64(ready))
+	desiredReplicasGauge.WithLabelValues(key.Namespace, key.Name).Set(float64(desired))
+}
+
+// observePods records the build duration of the docserver pods that have become ready.
+func (m *metricsRecorder) observePods(key types.NamespacedName, pods []corev1.Pod) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.state(key)
+	current := make(map[types.UID]bool, len(pods))
+	for _, pod := range pods {
+		current[pod.UID] = true
+		if s.pods[pod.UID] || pod.Status.StartTime == nil {
+			continue
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				s.pods[pod.UID] = true
+				if !cond.LastTransitionTime.Time.Before(m.started) {
+					buildDuration.WithLabelValues(key.Namespace, key.Name).
+						Observe(cond.LastTransitionTime.Sub(pod.Status.StartTime.Time).Seconds())
+				}
+			}
+		}
+	}
+	for uid := range s.pods {
+		if !current[uid] {
+			delete(s.pods, uid)
+		}
+	}
+}
+
+// forget deletes the metrics of the deleted DocServer.
+func (m *metricsRecorder) forget(key types.NamespacedName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.states, key)
+	labels := map[string]string{"namespace": key.Namespace, "name": key.Name}
+	syncDuration.Delete(labels)
+	lastSuccessfulSync.Delete(labels)
+	syncFailures.Delete(labels)
+	readyReplicas.Delete(labels)
+	desiredReplicasGauge.Delete(labels)
+	buildDuration.Delete(labels)
+}
+
+// Describe implements prometheus.Collector.
+func (m *metricsRecorder) Describe(ch chan<- *prometheus.Desc) {
+	ch <- servedCommitAge
+}
+
+// Collect implements prometheus.Collector.
+func (m *metricsRecorder) Collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, s := range m.states {
+		if len(s.commit) == 0 || s.commitTime.IsZero() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(servedCommitAge, prometheus.GaugeValue,
+			now.Sub(s.commitTime).Seconds(), key.Namespace, key.Name, s.commit)
+	}
+}
+
+// parseTerminationMessage parses the termination message of the gitpod container,
+// which consists of the SHA and optionally the committer date in unix seconds.
+func parseTerminationMessage(message string) (string, time.Time) {
+	fields := strings.Fields(message)
+	if len(fields) == 0 {
+		return "", time.Time{}
+	}
+	if len(fields) < 2 {
+		return fields[0], time.Time{}
+	}
+	committed, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return fields[0], time.Time{}
+	}
+	return fields[0], time.Unix(committed, 0)
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// sampleCount returns the number of the observations of the histogram of the DocServer.
+func sampleCount(t *testing.T, vec *prometheus.HistogramVec, key types.NamespacedName) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := vec.WithLabelValues(key.Namespace, key.Name).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// newMetricsKey returns the key of the DocServer whose metrics are deleted at the end of the test.
+func newMetricsKey(t *testing.T, m *metricsRecorder) types.NamespacedName {
+	key := types.NamespacedName{Namespace: "metrics", Name: strings.ReplaceAll(t.Name(), "/", "-")}
+	t.Cleanup(func() { m.forget(key) })
+	return key
+}
+
+func TestObserveJob(t *testing.T) {
+	m := newMetricsRecorder()
+	key := newMetricsKey(t, m)
+	start := metav1.NewTime(time.Now())
+	completion := metav1.NewTime(start.Add(30 * time.Second))
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{UID: "job-1", CreationTimestamp: start},
+		Status:     batchv1.JobStatus{Failed: 1, StartTime: &start},
+	}
+
+	m.observeJob(key, job)
+	m.observeJob(key, job)
+	if actual := testutil.ToFloat64(syncFailures.WithLabelValues(key.Namespace, key.Name)); actual != 1 {
+		t.Errorf("sync failures = %v, want 1", actual)
+	}
+
+	job.Status.Failed = 2
+	job.Status.Succeeded = 1
+	job.Status.CompletionTime = &completion
+	m.observeJob(key, job)
+	m.observeJob(key, job)
+	if actual := testutil.ToFloat64(syncFailures.WithLabelValues(key.Namespace, key.Name)); actual != 2 {
+		t.Errorf("sync failures = %v, want 2", actual)
+	}
+	if actual := sampleCount(t, syncDuration, key); actual != 1 {
+		t.Errorf("sync duration observations = %d, want 1", actual)
+	}
+	if actual := testutil.ToFloat64(lastSuccessfulSync.WithLabelValues(key.Namespace, key.Name)); actual != float64(completion.Unix()) {
+		t.Errorf("last successful sync = %v, want %d", actual, completion.Unix())
+	}
+
+	// The recreated Job is observed from the beginning.
+	m.observeJob(key, &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{UID: "job-2", CreationTimestamp: completion},
+		Status:     batchv1.JobStatus{Failed: 1, Succeeded: 1, StartTime: &start, CompletionTime: &completion},
+	})
+	if actual := testutil.ToFloat64(syncFailures.WithLabelValues(key.Namespace, key.Name)); actual != 3 {
+		t.Errorf("sync failures = %v, want 3", actual)
+	}
+	if actual := sampleCount(t, syncDuration, key); actual != 2 {
+		t.Errorf("sync duration observations = %d, want 2", actual)
+	}
+}
+
+func TestObserveJobAfterRestart(t *testing.T) {
+	m := newMetricsRecorder()
+	key := newMetricsKey(t, m)
+	created := metav1.NewTime(m.started.Add(-time.Hour))
+	completion := metav1.NewTime(m.started.Add(-time.Minute))
+
+	// The Job completed before the restart was observed by the previous process.
+	m.observeJob(key, &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{UID: "job", CreationTimestamp: created},
+		Status:     batchv1.JobStatus{Failed: 2, Succeeded: 1, StartTime: &created, CompletionTime: &completion},
+	})
+	if actual := testutil.ToFloat64(syncFailures.WithLabelValues(key.Namespace, key.Name)); actual != 0 {
+		t.Errorf("sync failures = %v, want 0", actual)
+	}
+	if actual := sampleCount(t, syncDuration, key); actual != 0 {
+		t.Errorf("sync duration observations = %d, want 0", actual)
+	}
+	if actual := testutil.ToFloat64(lastSuccessfulSync.WithLabelValues(key.Namespace, key.Name)); actual != float64(completion.Unix()) {
+		t.Errorf("last successful sync = %v, want %d", actual, completion.Unix())
+	}
+
+	// The Job created before the restart and completed after it counts only the new failures.
+	other := types.NamespacedName{Namespace: key.Namespace, Name: key.Name + "-running"}
+	t.Cleanup(func() { m.forget(other) })
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{UID: "running", CreationTimestamp: created},
+		Status:     batchv1.JobStatus{Failed: 1, StartTime: &created},
+	}
+	m.observeJob(other, job)
+	completion = metav1.NewTime(m.started.Add(time.Minute))
+	job.Status.Failed = 2
+	job.Status.Succeeded = 1
+	job.Status.CompletionTime = &completion
+	m.observeJob(other, job)
+	if actual := testutil.ToFloat64(syncFailures.WithLabelValues(other.Namespace, other.Name)); actual != 1 {
+		t.Errorf("sync failures = %v, want 1", actual)
+	}
+	if actual := sampleCount(t, syncDuration, other); actual != 1 {
+		t.Errorf("sync duration observations = %d, want 1", actual)
+	}
+}
+
+func TestObservePods(t *testing.T) {
+	m := newMetricsRecorder()
+	key := newMetricsKey(t, m)
+	readyPod := func(uid types.UID, ready time.Time) corev1.Pod {
+		start := metav1.NewTime(ready.Add(-10 * time.Second))
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{UID: uid},
+			Status: corev1.PodStatus{
+				StartTime: &start,
+				Conditions: []corev1.PodCondition{{
+					Type:               corev1.PodReady,
+					Status:             corev1.ConditionTrue,
+					LastTransitionTime: metav1.NewTime(ready),
+				}},
+			},
+		}
+	}
+	before := readyPod("before", m.started.Add(-time.Minute))
+	after := readyPod("after", m.started.Add(time.Minute))
+
+	m.observePods(key, []corev1.Pod{before, after})
+	m.observePods(key, []corev1.Pod{before, after})
+	if actual := sampleCount(t, buildDuration, key); actual != 1 {
+		t.Errorf("build duration observations = %d, want 1", actual)
+	}
+}
+
+func TestForget(t *testing.T) {
+	m := newMetricsRecorder()
+	key := newMetricsKey(t, m)
+	now := metav1.Now()
+	m.observeJob(key, &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{UID: "job", CreationTimestamp: now},
+		Status:     batchv1.JobStatus{Failed: 1, Succeeded: 1, StartTime: &now, CompletionTime: &now},
+	})
+	m.observeReplicas(key, 1, 2)
+	m.observeCommit(key, "0123456789abcdef", now.Time)
+
+	m.forget(key)
+
+	labels := prometheus.Labels{"namespace": key.Namespace, "name": key.Name}
+	for name, vec := range map[string]interface{ Delete(prometheus.Labels) bool }{
+		"sync duration":        syncDuration,
+		"last successful sync": lastSuccessfulSync,
+		"sync failures":        syncFailures,
+		"ready replicas":       readyReplicas,
+		"desired replicas":     desiredReplicasGauge,
+	} {
+		if vec.Delete(labels) {
+			t.Errorf("%s of the forgotten DocServer is left", name)
+		}
+	}
+	if actual := testutil.CollectAndCount(m); actual != 0 {
+		t.Errorf("served commit age of the forgotten DocServer is collected: %d", actual)
+	}
+}
+
+func TestCollectServedCommitAge(t *testing.T) {
+	m := newMetricsRecorder()
+	key := newMetricsKey(t, m)
+	m.observeCommit(key, "0123456789abcdef", time.Now().Add(-time.Hour))
+	// The commit without the committer date is not collected.
+	m.observeCommit(types.NamespacedName{Namespace: key.Namespace, Name: key.Name + "-unknown"}, "fedcba9876543210", time.Time{})
+
+	if actual := testutil.CollectAndCount(m, "docserver_served_commit_age_seconds"); actual != 1 {
+		t.Fatalf("served commit age series = %d, want 1", actual)
+	}
+	ch := make(chan prometheus.Metric, 1)
+	m.Collect(ch)
+	var metric dto.Metric
+	if err := (<-ch).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	if age := metric.GetGauge().GetValue(); age < time.Hour.Seconds() || age > time.Hour.Seconds()+60 {
+		t.Errorf("served commit age = %v, want about an hour", age)
+	}
+	labels := map[string]string{}
+	for _, label := range metric.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	if labels["commit"] != "0123456789abcdef" || labels["name"] != key.Name {
+		t.Errorf("served commit age labels = %v", labels)
+	}
+}
+
+func TestParseTerminationMessage(t *testing.T) {
+	tests := []struct {
+		message   string
+		commit    string
+		committed time.Time
+	}{
+		{message: "", commit: ""},
+		{message: "0123456789abcdef", commit: "0123456789abcdef"},
+		{message: "0123456789abcdef 1700000000\n", commit: "0123456789abcdef", committed: time.Unix(1700000000, 0)},
+		{message: "0123456789abcdef yesterday", commit: "0123456789abcdef"},
+	}
+	for _, tt := range tests {
+		commit, committed := parseTerminationMessage(tt.message)
+		if commit != tt.commit || !committed.Equal(tt.committed) {
+			t.Errorf("parseTerminationMessage(%q) = (%q, %v), want (%q, %v)", tt.message, commit, committed, tt.commit, tt.committed)
+		}
+	}
+}
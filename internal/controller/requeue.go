@@ -0,0 +1,72 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// DefaultMaxRequeueInterval is the default upper bound of the interval to re-check an unhealthy DocServer.
+	DefaultMaxRequeueInterval = 5 * time.Minute
+
+	// baseRequeueInterval is the first interval to re-check an unhealthy DocServer, which is doubled on each requeue.
+	baseRequeueInterval = 5 * time.Second
+)
+
+var requeues = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "docserver_requeues_total",
+		Help: "Total number of the reconciles requeued after an interval, by reason.",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(requeues)
+}
+
+// newRequeueBackoff returns the rate limiter that computes the exponential interval per DocServer.
+func newRequeueBackoff(maxInterval time.Duration) workqueue.RateLimiter {
+	if maxInterval <= 0 {
+		maxInterval = DefaultMaxRequeueInterval
+	}
+	base := baseRequeueInterval
+	if base > maxInterval {
+		base = maxInterval
+	}
+	return workqueue.NewItemExponentialFailureRateLimiter(base, maxInterval)
+}
+
+// requeueAfter returns the result to re-check the DocServer after the next interval.
+// The changes of the owned resources trigger the reconcile anyway, so this is only a fallback
+// for the changes that are not watched, e.g. the pods of the Deployment becoming unschedulable.
+func (r *DocServerReconciler) requeueAfter(key types.NamespacedName, reason string) ctrl.Result {
+	requeues.WithLabelValues(reason).Inc()
+	return ctrl.Result{RequeueAfter: r.requeueBackoff.When(key)}
+}
+
+// resetRequeue resets the interval of the DocServer.
+func (r *DocServerReconciler) resetRequeue(key types.NamespacedName) {
+	r.requeueBackoff.Forget(key)
+}
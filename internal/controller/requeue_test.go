@@ -0,0 +1,137 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newFakeReconciler returns the reconciler with the fake client and the fake recorder.
+// The fake client does not support the server-side apply, so it is used only for the steps without applying.
+func newFakeReconciler(t *testing.T, objs ...client.Object) (*DocServerReconciler, *record.FakeRecorder) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := updatev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	recorder := record.NewFakeRecorder(100)
+	return &DocServerReconciler{
+		Client:         fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Scheme:         scheme,
+		Recorder:       recorder,
+		requeueBackoff: newRequeueBackoff(20 * time.Second),
+	}, recorder
+}
+
+func TestUpdateStatusRequeue(t *testing.T) {
+	ds := &updatev1.DocServer{
+		ObjectMeta: metav1.ObjectMeta{Name: "requeue", Namespace: "default"},
+		Spec:       updatev1.DocServerSpec{Replicas: 1},
+	}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "docserver-" + ds.Name, Namespace: ds.Namespace},
+	}
+	r, _ := newFakeReconciler(t, ds, dep)
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(ds)
+	t.Cleanup(func() { docServerMetrics.forget(key) })
+
+	// reconcile updates the status with the latest DocServer, as the reconciles triggered one after another do.
+	reconcile := func() time.Duration {
+		t.Helper()
+		if err := r.Get(ctx, key, ds); err != nil {
+			t.Fatal(err)
+		}
+		result, err := r.updateStatus(ctx, *ds, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.RequeueAfter
+	}
+
+	counter := requeues.WithLabelValues("NoAvailableReplicas")
+	before := testutil.ToFloat64(counter)
+	for i, expected := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 20 * time.Second} {
+		if actual := reconcile(); actual != expected {
+			t.Errorf("requeue %d: RequeueAfter = %s, want %s", i, actual, expected)
+		}
+	}
+	if actual := testutil.ToFloat64(counter) - before; actual != 4 {
+		t.Errorf("docserver_requeues_total{reason=\"NoAvailableReplicas\"} increased by %v, want 4", actual)
+	}
+
+	dep.Status.AvailableReplicas = 1
+	if err := r.Status().Update(ctx, dep); err != nil {
+		t.Fatal(err)
+	}
+	if actual := reconcile(); actual != 0 {
+		t.Errorf("RequeueAfter of the healthy DocServer = %s, want 0", actual)
+	}
+	if err := r.Get(ctx, key, ds); err != nil {
+		t.Fatal(err)
+	}
+	if ds.Status.Phase != updatev1.DocServerHealthy {
+		t.Fatalf("phase = %s, want %s", ds.Status.Phase, updatev1.DocServerHealthy)
+	}
+	if actual := r.requeueBackoff.NumRequeues(key); actual != 0 {
+		t.Errorf("requeues of the healthy DocServer = %d, want 0", actual)
+	}
+
+	// The interval starts over when the DocServer becomes unhealthy again.
+	dep.Status.AvailableReplicas = 0
+	if err := r.Status().Update(ctx, dep); err != nil {
+		t.Fatal(err)
+	}
+	if actual := reconcile(); actual != 5*time.Second {
+		t.Errorf("RequeueAfter after recovery = %s, want %s", actual, 5*time.Second)
+	}
+}
+
+func TestNewRequeueBackoff(t *testing.T) {
+	key := client.ObjectKey{Namespace: "default", Name: "docs"}
+	tests := []struct {
+		maxInterval time.Duration
+		expected    []time.Duration
+	}{
+		{maxInterval: 0, expected: []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second}},
+		{maxInterval: 7 * time.Second, expected: []time.Duration{5 * time.Second, 7 * time.Second, 7 * time.Second}},
+		{maxInterval: time.Second, expected: []time.Duration{time.Second, time.Second}},
+	}
+	for _, tt := range tests {
+		backoff := newRequeueBackoff(tt.maxInterval)
+		for i, expected := range tt.expected {
+			if actual := backoff.When(key); actual != expected {
+				t.Errorf("max %s, requeue %d: interval = %s, want %s", tt.maxInterval, i, actual, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// pvcChanges returns the storage class and size applied to the existing PersistentVolumeClaim.
+// The changes the API server rejects are dropped so that the rest of the reconcile is not aborted:
+// the storage class is immutable, the claim cannot shrink and it can grow only when the storage class allows expansion.
+func (r *DocServerReconciler) pvcChanges(ctx context.Context, current *corev1.PersistentVolumeClaim, storageClassName, size string) (string, string, error) {
+	logger := childLogger(ctx, "PersistentVolumeClaim", current.Name)
+
+	if current.Spec.StorageClassName != nil && *current.Spec.StorageClassName != storageClassName {
+		logger.Info("storage class of PersistentVolumeClaim is immutable, keep the current one",
+			"current", *current.Spec.StorageClassName, "desired", storageClassName)
+		storageClassName = *current.Spec.StorageClassName
+	}
+
+	currentSize, ok := current.Spec.Resources.Requests[corev1.ResourceStorage]
+	if !ok {
+		return storageClassName, size, nil
+	}
+	desiredSize, err := resource.ParseQuantity(size)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch desiredSize.Cmp(currentSize) {
+	case -1:
+		logger.Info("PersistentVolumeClaim cannot be shrunk, keep the current size",
+			"current", currentSize.String(), "desired", size)
+		size = currentSize.String()
+	case 1:
+		allowed, err := r.allowsVolumeExpansion(ctx, storageClassName)
+		if err != nil {
+			return "", "", err
+		}
+		if !allowed {
+			logger.Info("storage class does not allow volume expansion, keep the current size",
+				"storageClass", storageClassName, "current", currentSize.String(), "desired", size)
+			size = currentSize.String()
+		} else {
+			logger.Info("expanding PersistentVolumeClaim", "current", currentSize.String(), "desired", size)
+		}
+	}
+
+	for _, cond := range current.Status.Conditions {
+		if cond.Type == corev1.PersistentVolumeClaimFileSystemResizePending && cond.Status == corev1.ConditionTrue {
+			logger.Info("PersistentVolumeClaim is waiting for the file system resize on the node")
+		}
+	}
+
+	return storageClassName, size, nil
+}
+
+// allowsVolumeExpansion returns whether or not the storage class allows the volumes to be expanded.
+func (r *DocServerReconciler) allowsVolumeExpansion(ctx context.Context, storageClassName string) (bool, error) {
+	var sc storagev1.StorageClass
+	err := r.Get(ctx, client.ObjectKey{Name: storageClassName}, &sc)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion, nil
+}
+
+// sourceVolume returns the volume where the sources are stored, which is mounted by both the gitpod and docserver pods.
+func sourceVolume(ds updatev1.DocServer) (*corev1apply.VolumeApplyConfiguration, error) {
+	volume := corev1apply.Volume()
+	switch {
+	case ds.Spec.Storage.VolumeSource != nil:
+		err := convertApplyConfiguration(ds.Spec.Storage.VolumeSource, volume)
+		if err != nil {
+			return nil, err
+		}
+	case len(ds.Spec.Storage.ExistingClaim) != 0:
+		volume.WithPersistentVolumeClaim(corev1apply.PersistentVolumeClaimVolumeSource().
+			WithClaimName(ds.Spec.Storage.ExistingClaim),
+		)
+	default:
+		volume.WithPersistentVolumeClaim(corev1apply.PersistentVolumeClaimVolumeSource().
+			WithClaimName("docserver-" + ds.Name),
+		)
+	}
+	return volume.WithName("source"), nil
+}
+
+// accessModes returns the access modes of the PersistentVolumeClaim created by the controller.
+func accessModes(ds updatev1.DocServer) []corev1.PersistentVolumeAccessMode {
+	if len(ds.Spec.Storage.AccessModes) == 0 {
+		return []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+	}
+	return ds.Spec.Storage.AccessModes
+}
+
+// volumeMode returns the volume mode of the PersistentVolumeClaim created by the controller.
+func volumeMode(ds updatev1.DocServer) corev1.PersistentVolumeMode {
+	if ds.Spec.Storage.VolumeMode == nil || ds.Spec.Storage.IsExternal() {
+		return corev1.PersistentVolumeFilesystem
+	}
+	return *ds.Spec.Storage.VolumeMode
+}
+
+// mountSourceVolume attaches the source volume to the container, as the raw block device
+// if the PersistentVolumeClaim is in the Block volume mode.
+func mountSourceVolume(container *corev1apply.ContainerApplyConfiguration, ds updatev1.DocServer) {
+	if volumeMode(ds) == corev1.PersistentVolumeBlock {
+		container.WithVolumeDevices(corev1apply.VolumeDevice().
+			WithName("source").
+			WithDevicePath("/dev/docs"),
+		)
+		return
+	}
+	container.WithVolumeMounts(corev1apply.VolumeMount().
+		WithName("source").
+		WithMountPath("/docs"),
+	)
+}
+
+// requiresSameNode returns whether or not the pods have to run on the same node to mount the PersistentVolumeClaim.
+func requiresSameNode(ds updatev1.DocServer) bool {
+	if ds.Spec.Storage.IsExternal() {
+		return false
+	}
+	for _, mode := range accessModes(ds) {
+		if mode == corev1.ReadWriteMany || mode == corev1.ReadOnlyMany {
+			return false
+		}
+	}
+	return true
+}
+
+// isReadWriteOncePod returns whether or not the PersistentVolumeClaim can be mounted by only one pod.
+func isReadWriteOncePod(ds updatev1.DocServer) bool {
+	if ds.Spec.Storage.IsExternal() {
+		return false
+	}
+	for _, mode := range accessModes(ds) {
+		if mode == corev1.ReadWriteOncePod {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCoScheduling adds the pod affinity that schedules the gitpod and docserver pods on the same node
+// when the PersistentVolumeClaim cannot be mounted from multiple nodes. The affinity from the class is kept.
+func applyCoScheduling(spec *corev1apply.PodSpecApplyConfiguration, ds updatev1.DocServer) {
+	if !requiresSameNode(ds) {
+		return
+	}
+	if spec.Affinity == nil {
+		spec.WithAffinity(corev1apply.Affinity())
+	}
+	if spec.Affinity.PodAffinity == nil {
+		spec.Affinity.WithPodAffinity(corev1apply.PodAffinity())
+	}
+	spec.Affinity.PodAffinity.WithRequiredDuringSchedulingIgnoredDuringExecution(corev1apply.PodAffinityTerm().
+		WithLabelSelector(metav1apply.LabelSelector().WithMatchLabels(map[string]string{
+			"app.kubernetes.io/name":     "mkdocs",
+			"app.kubernetes.io/instance": ds.Name,
+		})).
+		WithTopologyKey(corev1.LabelHostname),
+	)
+}
+
+// syncInProgress returns whether or not the gitpod Job has neither completed nor failed yet.
+func (r *DocServerReconciler) syncInProgress(ctx context.Context, ds updatev1.DocServer) (bool, error) {
+	var job batchv1.Job
+	err := r.Get(ctx, client.ObjectKey{Namespace: ds.Namespace, Name: "gitpod-" + ds.Name}, &job)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, cond := range job.Status.Conditions {
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) && cond.Status == corev1.ConditionTrue {
+			return false, nil
+		}
+	}
+	return true, nil
+}
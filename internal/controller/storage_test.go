@@ -0,0 +1,292 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+var _ = Describe("DocServer storage", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	newDocServer := func(name string, storage updatev1.Storage) *updatev1.DocServer {
+		return &updatev1.DocServer{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Spec: updatev1.DocServerSpec{
+				Source: updatev1.Source{Git: updatev1.GitSource{
+					URL:    "https://github.com/git-ogawa/docserver.git",
+					Branch: "main",
+					Depth:  1,
+				}},
+				Replicas: 1,
+				Storage:  storage,
+			},
+		}
+	}
+
+	// childrenOf waits for the Job and the Deployment of the DocServer to be created.
+	childrenOf := func(ds *updatev1.DocServer) (*batchv1.Job, *appsv1.Deployment) {
+		var job batchv1.Job
+		var dep appsv1.Deployment
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "gitpod-" + ds.Name}, &job)).To(Succeed())
+			g.Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}, &dep)).To(Succeed())
+		}, timeout, interval).Should(Succeed())
+		return &job, &dep
+	}
+
+	// expectNoClaim checks that the PersistentVolumeClaim is not created for the external storage.
+	expectNoClaim := func(ds *updatev1.DocServer) {
+		var pvc corev1.PersistentVolumeClaim
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}, &pvc)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	}
+
+	It("mounts the existing claim instead of creating the PersistentVolumeClaim", func() {
+		ds := newDocServer("existing-claim", updatev1.Storage{ExistingClaim: "shared-docs"})
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		job, dep := childrenOf(ds)
+		for _, spec := range []corev1.PodSpec{job.Spec.Template.Spec, dep.Spec.Template.Spec} {
+			Expect(spec.Volumes).To(HaveLen(1))
+			Expect(spec.Volumes[0].Name).To(Equal("source"))
+			Expect(spec.Volumes[0].PersistentVolumeClaim).NotTo(BeNil())
+			Expect(spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("shared-docs"))
+			Expect(spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "source", MountPath: "/docs"}))
+		}
+		expectNoClaim(ds)
+	})
+
+	It("mounts the volume source instead of creating the PersistentVolumeClaim", func() {
+		nfs := &corev1.NFSVolumeSource{Server: "nfs.example.com", Path: "/exports/docs"}
+		ds := newDocServer("volume-source", updatev1.Storage{VolumeSource: &corev1.VolumeSource{NFS: nfs}})
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		job, dep := childrenOf(ds)
+		for _, spec := range []corev1.PodSpec{job.Spec.Template.Spec, dep.Spec.Template.Spec} {
+			Expect(spec.Volumes).To(HaveLen(1))
+			Expect(spec.Volumes[0].Name).To(Equal("source"))
+			Expect(spec.Volumes[0].NFS).To(Equal(nfs))
+			Expect(spec.Volumes[0].PersistentVolumeClaim).To(BeNil())
+			Expect(spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "source", MountPath: "/docs"}))
+		}
+		expectNoClaim(ds)
+	})
+
+	It("attaches the Block volume as the raw device", func() {
+		block := corev1.PersistentVolumeBlock
+		ds := newDocServer("block-storage", updatev1.Storage{VolumeMode: &block})
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		var pvc corev1.PersistentVolumeClaim
+		Eventually(func() error {
+			return k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "docserver-" + ds.Name}, &pvc)
+		}, timeout, interval).Should(Succeed())
+		Expect(pvc.Spec.VolumeMode).To(HaveValue(Equal(corev1.PersistentVolumeBlock)))
+
+		job, dep := childrenOf(ds)
+		for _, container := range []corev1.Container{job.Spec.Template.Spec.Containers[0], dep.Spec.Template.Spec.Containers[0]} {
+			Expect(container.VolumeMounts).To(BeEmpty())
+			Expect(container.VolumeDevices).To(ConsistOf(corev1.VolumeDevice{Name: "source", DevicePath: "/dev/docs"}))
+		}
+	})
+
+	It("stops the docserver pods while the gitpod pod runs with ReadWriteOncePod", func() {
+		ds := newDocServer("read-write-once-pod", updatev1.Storage{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod},
+		})
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		// The Job never completes in envtest, so the sync is kept in progress.
+		job, dep := childrenOf(ds)
+		Expect(dep.Spec.Strategy.Type).To(Equal(appsv1.RecreateDeploymentStrategyType))
+		Expect(dep.Spec.Replicas).To(HaveValue(BeEquivalentTo(0)))
+		for _, spec := range []corev1.PodSpec{job.Spec.Template.Spec, dep.Spec.Template.Spec} {
+			Expect(spec.Affinity).NotTo(BeNil())
+			Expect(spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(HaveLen(1))
+		}
+	})
+})
+
+func TestAccessModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		storage  updatev1.Storage
+		expected []corev1.PersistentVolumeAccessMode
+	}{
+		{
+			name:     "default",
+			expected: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+		},
+		{
+			name:     "specified",
+			storage:  updatev1.Storage{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}},
+			expected: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := accessModes(updatev1.DocServer{Spec: updatev1.DocServerSpec{Storage: tt.storage}})
+			if !equality.Semantic.DeepEqual(actual, tt.expected) {
+				t.Errorf("accessModes() = %v, want %v", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVolumeMode(t *testing.T) {
+	block := corev1.PersistentVolumeBlock
+	tests := []struct {
+		name     string
+		storage  updatev1.Storage
+		expected corev1.PersistentVolumeMode
+	}{
+		{name: "default", expected: corev1.PersistentVolumeFilesystem},
+		{name: "block", storage: updatev1.Storage{VolumeMode: &block}, expected: corev1.PersistentVolumeBlock},
+		{name: "existing claim", storage: updatev1.Storage{VolumeMode: &block, ExistingClaim: "docs"}, expected: corev1.PersistentVolumeFilesystem},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := volumeMode(updatev1.DocServer{Spec: updatev1.DocServerSpec{Storage: tt.storage}})
+			if actual != tt.expected {
+				t.Errorf("volumeMode() = %s, want %s", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMountSourceVolume(t *testing.T) {
+	block := corev1.PersistentVolumeBlock
+	container := corev1apply.Container()
+	mountSourceVolume(container, updatev1.DocServer{})
+	if len(container.VolumeMounts) != 1 || *container.VolumeMounts[0].MountPath != "/docs" || len(container.VolumeDevices) != 0 {
+		t.Errorf("Filesystem volume is not mounted at /docs: %+v", container)
+	}
+
+	container = corev1apply.Container()
+	mountSourceVolume(container, updatev1.DocServer{Spec: updatev1.DocServerSpec{Storage: updatev1.Storage{VolumeMode: &block}}})
+	if len(container.VolumeDevices) != 1 || *container.VolumeDevices[0].DevicePath != "/dev/docs" || len(container.VolumeMounts) != 0 {
+		t.Errorf("Block volume is not attached at /dev/docs: %+v", container)
+	}
+}
+
+func TestRequiresSameNode(t *testing.T) {
+	tests := []struct {
+		name             string
+		storage          updatev1.Storage
+		sameNode         bool
+		readWriteOncePod bool
+	}{
+		{name: "default", sameNode: false},
+		{
+			name:     "ReadWriteOnce",
+			storage:  updatev1.Storage{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}},
+			sameNode: true,
+		},
+		{
+			name:     "ReadWriteOnce and ReadOnlyMany",
+			storage:  updatev1.Storage{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce, corev1.ReadOnlyMany}},
+			sameNode: false,
+		},
+		{
+			name:             "ReadWriteOncePod",
+			storage:          updatev1.Storage{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}},
+			sameNode:         true,
+			readWriteOncePod: true,
+		},
+		{
+			name: "existing claim",
+			storage: updatev1.Storage{
+				AccessModes:   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod},
+				ExistingClaim: "docs",
+			},
+			sameNode:         false,
+			readWriteOncePod: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := updatev1.DocServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "docs"},
+				Spec:       updatev1.DocServerSpec{Storage: tt.storage},
+			}
+			if actual := requiresSameNode(ds); actual != tt.sameNode {
+				t.Errorf("requiresSameNode() = %t, want %t", actual, tt.sameNode)
+			}
+			if actual := isReadWriteOncePod(ds); actual != tt.readWriteOncePod {
+				t.Errorf("isReadWriteOncePod() = %t, want %t", actual, tt.readWriteOncePod)
+			}
+
+			spec := corev1apply.PodSpec()
+			applyCoScheduling(spec, ds)
+			if hasAffinity := spec.Affinity != nil; hasAffinity != tt.sameNode {
+				t.Errorf("applyCoScheduling() added the affinity = %t, want %t", hasAffinity, tt.sameNode)
+			}
+		})
+	}
+}
+
+func TestApplyCoSchedulingKeepsAffinity(t *testing.T) {
+	ds := updatev1.DocServer{
+		ObjectMeta: metav1.ObjectMeta{Name: "docs"},
+		Spec: updatev1.DocServerSpec{Storage: updatev1.Storage{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+		}},
+	}
+	spec := corev1apply.PodSpec().WithAffinity(corev1apply.Affinity().
+		WithNodeAffinity(corev1apply.NodeAffinity()).
+		WithPodAffinity(corev1apply.PodAffinity().
+			WithRequiredDuringSchedulingIgnoredDuringExecution(corev1apply.PodAffinityTerm().
+				WithTopologyKey("topology.kubernetes.io/zone"),
+			),
+		),
+	)
+	applyCoScheduling(spec, ds)
+
+	if spec.Affinity.NodeAffinity == nil {
+		t.Error("node affinity from the class is dropped")
+	}
+	terms := spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 2 {
+		t.Fatalf("pod affinity terms = %d, want 2", len(terms))
+	}
+	term := terms[1]
+	if *term.TopologyKey != corev1.LabelHostname {
+		t.Errorf("topology key = %s, want %s", *term.TopologyKey, corev1.LabelHostname)
+	}
+	expected := map[string]string{"app.kubernetes.io/name": "mkdocs", "app.kubernetes.io/instance": "docs"}
+	if !equality.Semantic.DeepEqual(term.LabelSelector.MatchLabels, expected) {
+		t.Errorf("label selector = %v, want %v", term.LabelSelector.MatchLabels, expected)
+	}
+}
@@ -18,17 +18,20 @@ package controller
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	updatev1beta1 "github.com/git-ogawa/docserver/api/v1beta1"
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -44,6 +47,8 @@ var (
 	k8sClient client.Client
 	testEnv   *envtest.Environment
 	scheme    = runtime.NewScheme()
+	ctx       context.Context
+	cancel    context.CancelFunc
 )
 
 func TestAPIs(t *testing.T) {
@@ -55,11 +60,19 @@ func TestAPIs(t *testing.T) {
 var _ = BeforeSuite(func() {
 	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 
+	if len(os.Getenv("KUBEBUILDER_ASSETS")) == 0 {
+		Skip("KUBEBUILDER_ASSETS is not set, run the tests with make test")
+	}
+
+	ctx, cancel = context.WithCancel(context.TODO())
+
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
 		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
 		ErrorIfCRDPathMissing: true,
 	}
+	// ReadWriteOncePod is not enabled by default before Kubernetes 1.27.
+	testEnv.ControlPlane.GetAPIServer().Configure().Append("feature-gates", "ReadWriteOncePod=true")
 
 	var err error
 	// cfg is defined in this file globally.
@@ -67,8 +80,8 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
 
-	// err = updatev1beta1.AddToScheme(scheme.Scheme)
-	err = updatev1beta1.AddToScheme(scheme)
+	// err = updatev1.AddToScheme(scheme.Scheme)
+	err = updatev1.AddToScheme(scheme)
 	Expect(err).NotTo(HaveOccurred())
 	err = clientgoscheme.AddToScheme(scheme)
 	Expect(err).NotTo(HaveOccurred())
@@ -84,10 +97,60 @@ var _ = BeforeSuite(func() {
 	ns.Name = "test"
 	err = k8sClient.Create(context.Background(), ns)
 	Expect(err).NotTo(HaveOccurred())
+
+	// start the controller using Manager
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme,
+		LeaderElection:     false,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&DocServerReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("docserver-controller"),
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	go func() {
+		defer GinkgoRecover()
+		err = mgr.Start(ctx)
+		Expect(err).NotTo(HaveOccurred())
+	}()
 })
 
 var _ = AfterSuite(func() {
+	if testEnv == nil {
+		return
+	}
+	cancel()
 	By("tearing down the test environment")
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// updateDocServer updates the DocServer with mutate, retrying on the conflicts with the controller.
+func updateDocServer(ds *updatev1.DocServer, mutate func(ds *updatev1.DocServer)) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(ds), ds); err != nil {
+			return err
+		}
+		mutate(ds)
+		return k8sClient.Update(ctx, ds)
+	})
+	Expect(err).NotTo(HaveOccurred())
+}
+
+// eventReasons returns the reasons of the events recorded on the DocServer.
+func eventReasons(g Gomega, ds *updatev1.DocServer) []string {
+	var events corev1.EventList
+	g.Expect(k8sClient.List(ctx, &events, client.InNamespace(ds.Namespace))).To(Succeed())
+	var reasons []string
+	for _, e := range events.Items {
+		if e.InvolvedObject.Kind == "DocServer" && e.InvolvedObject.Name == ds.Name {
+			reasons = append(reasons, e.Reason)
+		}
+	}
+	return reasons
+}
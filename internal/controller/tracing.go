@@ -0,0 +1,84 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+	"github.com/git-ogawa/docserver/internal/tracing"
+)
+
+var tracer = otel.Tracer("github.com/git-ogawa/docserver/internal/controller")
+
+// startSpan starts the span with the attributes of the DocServer and the reconcileID, which correlates the span with the logs.
+func startSpan(ctx context.Context, name string, ds updatev1.DocServer) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("docserver.namespace", ds.Namespace),
+		attribute.String("docserver.name", ds.Name),
+		attribute.String("docserver.uid", string(ds.UID)),
+		attribute.Int64("docserver.generation", ds.Generation),
+		attribute.String("docserver.class", ds.Spec.ClassName),
+		attribute.String("docserver.reconcile_id", string(controller.ReconcileIDFromContext(ctx))),
+	))
+}
+
+// endSpan records the error if any and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceContextEnv returns the environment variables propagating the trace context to the gitpod container.
+// When the Job already exists, the variables of the existing container are returned.
+func traceContextEnv(ctx context.Context, current batchv1.Job) []corev1apply.EnvVarApplyConfiguration {
+	var env []corev1apply.EnvVarApplyConfiguration
+	if len(current.UID) == 0 {
+		traceEnv := tracing.InjectEnv(ctx)
+		for _, name := range sortedKeys(traceEnv) {
+			env = append(env, *corev1apply.EnvVar().WithName(name).WithValue(traceEnv[name]))
+		}
+		return env
+	}
+
+	fields := map[string]bool{}
+	for _, name := range tracing.PropagatedEnv() {
+		fields[name] = true
+	}
+	for _, c := range current.Spec.Template.Spec.Containers {
+		if c.Name != "gitpod" {
+			continue
+		}
+		for _, e := range c.Env {
+			if fields[e.Name] {
+				env = append(env, *corev1apply.EnvVar().WithName(e.Name).WithValue(e.Value))
+			}
+		}
+	}
+	return env
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	updatev1 "github.com/git-ogawa/docserver/api/v1"
+)
+
+var _ = Describe("DocServer tracing", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	var recorder *tracetest.SpanRecorder
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		DeferCleanup(func() {
+			otel.SetTracerProvider(prevProvider)
+			otel.SetTextMapPropagator(prevPropagator)
+			Expect(provider.Shutdown(ctx)).To(Succeed())
+		})
+	})
+
+	// endedSpans returns the ended spans of the DocServer by the names.
+	endedSpans := func(name string) map[string][]sdktrace.ReadOnlySpan {
+		spans := map[string][]sdktrace.ReadOnlySpan{}
+		for _, span := range recorder.Ended() {
+			for _, attr := range span.Attributes() {
+				if attr == attribute.String("docserver.name", name) {
+					spans[span.Name()] = append(spans[span.Name()], span)
+				}
+			}
+		}
+		return spans
+	}
+
+	It("records the reconcile and the child apply spans, and propagates the trace context to the Job", func() {
+		ds := &updatev1.DocServer{
+			ObjectMeta: metav1.ObjectMeta{Name: "tracing", Namespace: "test"},
+			Spec: updatev1.DocServerSpec{
+				Source: updatev1.Source{Git: updatev1.GitSource{
+					URL:    "https://github.com/git-ogawa/docserver.git",
+					Branch: "main",
+					Depth:  1,
+				}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, ds)).To(Succeed())
+
+		var reconcile, reconcileJob sdktrace.ReadOnlySpan
+		Eventually(func(g Gomega) {
+			spans := endedSpans(ds.Name)
+			g.Expect(spans).To(HaveKey("Reconcile"))
+			g.Expect(spans).To(HaveKey("reconcileJob"))
+			reconcileJob = spans["reconcileJob"][0]
+			for _, span := range spans["Reconcile"] {
+				if span.SpanContext().SpanID() == reconcileJob.Parent().SpanID() {
+					reconcile = span
+				}
+			}
+			g.Expect(reconcile).NotTo(BeNil())
+			for _, child := range []string{"reconcilePersistenVolumeClaim", "reconcileDeployment", "reconcileService"} {
+				g.Expect(spans).To(HaveKey(child))
+			}
+		}, timeout, interval).Should(Succeed())
+
+		Expect(reconcileJob.SpanContext().TraceID()).To(Equal(reconcile.SpanContext().TraceID()))
+		var reconcileID string
+		for _, attr := range reconcile.Attributes() {
+			if attr.Key == "docserver.reconcile_id" {
+				reconcileID = attr.Value.AsString()
+			}
+		}
+		Expect(reconcileID).NotTo(BeEmpty())
+
+		var job batchv1.Job
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "test", Name: "gitpod-" + ds.Name}, &job)).To(Succeed())
+		var traceparent string
+		for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+			if e.Name == "TRACEPARENT" {
+				traceparent = e.Value
+			}
+		}
+		Expect(traceparent).To(ContainSubstring(reconcileJob.SpanContext().TraceID().String()))
+	})
+})
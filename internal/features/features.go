@@ -0,0 +1,60 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package features defines the feature gates of the controller.
+// The new behaviors are added as alpha features disabled by default, and are opted in by --feature-gates.
+//
+// SnapshotRetention and CustomMetricsAutoscaling are beta and enabled by default because they were
+// available before the feature gates were introduced, so their gates are opt-out kill switches: disabling them
+// rejects the new uses of the features on the clusters without the components they require,
+// while the existing DocServers that already use them keep working.
+package features
+
+import (
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/component-base/featuregate"
+)
+
+const (
+	// SnapshotRetention enables the Snapshot retention policy of the PersistentVolumeClaim,
+	// which requires the CSI snapshot controller and its CRDs in the cluster.
+	SnapshotRetention featuregate.Feature = "SnapshotRetention"
+
+	// CustomMetricsAutoscaling enables the autoscaling on the requests per second,
+	// which requires the custom metrics API served by e.g. prometheus-adapter.
+	CustomMetricsAutoscaling featuregate.Feature = "CustomMetricsAutoscaling"
+)
+
+// defaultFeatureGates are the known features and their defaults.
+var defaultFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
+	SnapshotRetention:        {Default: true, PreRelease: featuregate.Beta},
+	CustomMetricsAutoscaling: {Default: true, PreRelease: featuregate.Beta},
+}
+
+// DefaultMutableFeatureGate is the feature gate set from the configuration at startup.
+var DefaultMutableFeatureGate featuregate.MutableFeatureGate = featuregate.NewFeatureGate()
+
+// DefaultFeatureGate is the read-only view of DefaultMutableFeatureGate.
+var DefaultFeatureGate featuregate.FeatureGate = DefaultMutableFeatureGate
+
+func init() {
+	utilruntime.Must(DefaultMutableFeatureGate.Add(defaultFeatureGates))
+}
+
+// Enabled returns true if the feature is enabled.
+func Enabled(feature featuregate.Feature) bool {
+	return DefaultFeatureGate.Enabled(feature)
+}
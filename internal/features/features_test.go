@@ -0,0 +1,46 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package features
+
+import (
+	"testing"
+
+	"k8s.io/component-base/featuregate"
+)
+
+func TestDefaults(t *testing.T) {
+	// The beta features are enabled by default, so that disabling them is an opt-out kill switch.
+	for _, feature := range []featuregate.Feature{SnapshotRetention, CustomMetricsAutoscaling} {
+		spec, ok := defaultFeatureGates[feature]
+		if !ok {
+			t.Fatalf("%s is not registered", feature)
+		}
+		if !spec.Default || spec.PreRelease != featuregate.Beta {
+			t.Errorf("%s is %s and enabled=%t by default, want Beta and enabled", feature, spec.PreRelease, spec.Default)
+		}
+		if !Enabled(feature) {
+			t.Errorf("%s is disabled", feature)
+		}
+	}
+
+	// The new features must be opted in.
+	for feature, spec := range defaultFeatureGates {
+		if spec.PreRelease == featuregate.Alpha && spec.Default {
+			t.Errorf("alpha feature %s is enabled by default", feature)
+		}
+	}
+}
@@ -0,0 +1,486 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gitpod implements the sync of the document sources from a git repository
+// into the volume shared with the docserver pods.
+package gitpod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/git-ogawa/docserver/internal/giturl"
+)
+
+const (
+	// DefaultDocsDir is the directory where the sources are stored.
+	DefaultDocsDir = "/docs"
+
+	// DefaultWorkDir is the directory where the repository is cloned.
+	DefaultWorkDir = "/opt/gitpod/work"
+
+	// DefaultMountDir is the directory where the volumes of the ssh files and the CA certificate are mounted.
+	DefaultMountDir = "/opt/gitpod"
+
+	// The directories and files under the mount directory.
+	sshConfigDir  = "sshconfig"
+	privateKeyDir = "privatekey"
+	knownHostsDir = "knownhosts"
+	caCertFile    = "certs/ca.crt"
+)
+
+// identityKeys are the keys of the private key secret recognized as the ssh private keys in addition to id_*,
+// e.g. identity of Flux and sshPrivateKey of Argo CD.
+var identityKeys = map[string]bool{
+	"identity":      true,
+	"sshPrivateKey": true,
+}
+
+var tracer = otel.Tracer("github.com/git-ogawa/docserver/internal/gitpod")
+
+// Config is the configuration of the sync, which is given by the environment variables set by the controller.
+type Config struct {
+	URL                   string
+	Repository            *giturl.URL
+	Branch                string
+	Depth                 int
+	SSLVerify             bool
+	Username              string
+	Password              string
+	StrictHostKeyChecking *bool
+	KnownHosts            string
+	LFS                   bool
+	Submodules            bool
+	SubmodulesRecursive   bool
+	SubmodulesShallow     bool
+
+	DocsDir  string
+	WorkDir  string
+	MountDir string
+	HomeDir  string
+}
+
+// ConfigFromEnv returns the Config read from the environment variables.
+func ConfigFromEnv() (Config, error) {
+	c := Config{
+		URL:                 os.Getenv("GIT_URL"),
+		Branch:              os.Getenv("GIT_BRANCH"),
+		Depth:               1,
+		SSLVerify:           os.Getenv("GIT_SSL_VERIFY") != "false",
+		Username:            os.Getenv("GIT_USERNAME"),
+		Password:            os.Getenv("GIT_PASSWORD"),
+		KnownHosts:          os.Getenv("GIT_SSH_KNOWN_HOSTS"),
+		LFS:                 os.Getenv("GIT_LFS") == "true",
+		Submodules:          os.Getenv("GIT_SUBMODULES") == "true",
+		SubmodulesRecursive: os.Getenv("GIT_SUBMODULES_RECURSIVE") == "true",
+		SubmodulesShallow:   os.Getenv("GIT_SUBMODULES_SHALLOW") == "true",
+		DocsDir:             DefaultDocsDir,
+		WorkDir:             DefaultWorkDir,
+		MountDir:            DefaultMountDir,
+	}
+
+	repository, err := giturl.Parse(c.URL)
+	if err != nil {
+		return c, fmt.Errorf("invalid GIT_URL: %w", err)
+	}
+	c.Repository = repository
+	if len(c.Branch) == 0 {
+		c.Branch = "main"
+	}
+
+	if v := os.Getenv("GIT_DEPTH"); len(v) != 0 {
+		depth, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid GIT_DEPTH %q: %w", v, err)
+		}
+		c.Depth = depth
+	}
+
+	if v := os.Getenv("GIT_SSH_STRICT_HOST_KEY_CHECKING"); len(v) != 0 {
+		strict, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid GIT_SSH_STRICT_HOST_KEY_CHECKING %q: %w", v, err)
+		}
+		c.StrictHostKeyChecking = &strict
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return c, err
+	}
+	c.HomeDir = home
+
+	return c, nil
+}
+
+// Syncer pulls the sources from the repository.
+type Syncer struct {
+	Config Config
+	Log    logr.Logger
+
+	env []string
+}
+
+// Prepare sets up the ssh files and git configurations used by the following syncs.
+func (s *Syncer) Prepare(ctx context.Context) error {
+	c := s.Config
+	s.env = os.Environ()
+
+	var gitConfig [][2]string
+	if !c.SSLVerify {
+		gitConfig = append(gitConfig, [2]string{"http.sslVerify", "false"})
+	}
+	// The credentials are given by the helper so that the submodules on any host are
+	// fetched with the same credentials as the repository.
+	if len(c.Username) != 0 && len(c.Password) != 0 {
+		gitConfig = append(gitConfig, [2]string{"credential.helper",
+			`!f() { echo "username=${GIT_USERNAME}"; echo "password=${GIT_PASSWORD}"; }; f`})
+	}
+	s.env = append(s.env, "GIT_CONFIG_COUNT="+strconv.Itoa(len(gitConfig)))
+	for i, kv := range gitConfig {
+		s.env = append(s.env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]),
+		)
+	}
+
+	if caCert := filepath.Join(c.MountDir, caCertFile); fileExists(caCert) {
+		s.env = append(s.env, "GIT_SSL_CAINFO="+caCert)
+	}
+
+	if err := s.prepareSSH(); err != nil {
+		return err
+	}
+
+	if c.LFS {
+		if _, err := s.git(ctx, "", "lfs", "install", "--skip-repo"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Syncer) prepareSSH() error {
+	c := s.Config
+	sshDir := filepath.Join(c.HomeDir, ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return err
+	}
+
+	// The secret of the private key may also hold known_hosts.
+	for _, dir := range []string{sshConfigDir, privateKeyDir} {
+		if err := copyDir(filepath.Join(c.MountDir, dir), sshDir, 0600); err != nil {
+			return err
+		}
+	}
+	identities, err := identityFiles(filepath.Join(c.MountDir, privateKeyDir), sshDir)
+	if err != nil {
+		return err
+	}
+
+	knownHosts, err := os.ReadFile(filepath.Join(c.MountDir, knownHostsDir, "known_hosts"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(c.KnownHosts) != 0 {
+		knownHosts = append(knownHosts, []byte("\n"+c.KnownHosts+"\n")...)
+	}
+	if len(knownHosts) != 0 {
+		if err := appendFile(filepath.Join(sshDir, "known_hosts"), knownHosts); err != nil {
+			return err
+		}
+	}
+
+	var sshArgs []string
+	// ssh only tries the default key names such as id_rsa, so the keys of the secret are given explicitly,
+	// and IdentitiesOnly keeps ssh from offering the other keys.
+	for _, identity := range identities {
+		sshArgs = append(sshArgs, "-i", identity)
+	}
+	if len(identities) != 0 {
+		sshArgs = append(sshArgs, "-o", "IdentitiesOnly=yes")
+	}
+	if c.StrictHostKeyChecking != nil {
+		if *c.StrictHostKeyChecking {
+			sshArgs = append(sshArgs, "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile="+filepath.Join(sshDir, "known_hosts"))
+		} else {
+			sshArgs = append(sshArgs, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+		}
+	}
+	if len(sshArgs) != 0 {
+		s.env = append(s.env, "GIT_SSH_COMMAND=ssh "+strings.Join(sshArgs, " "))
+	}
+	return nil
+}
+
+// identityFiles returns the paths in sshDir of the private keys copied from src, whose permission is restricted to 0600
+// since ssh refuses the keys readable by the others.
+func identityFiles(src, sshDir string) ([]string, error) {
+	entries, err := os.ReadDir(src)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var identities []string
+	for _, e := range entries {
+		name := e.Name()
+		if !identityKeys[name] && !(strings.HasPrefix(name, "id_") && !strings.HasSuffix(name, ".pub")) {
+			continue
+		}
+		path := filepath.Join(sshDir, name)
+		if !fileExists(path) {
+			continue
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			return nil, err
+		}
+		identities = append(identities, path)
+	}
+	return identities, nil
+}
+
+// Commit is the commit that has been synced.
+type Commit struct {
+	SHA  string
+	Time time.Time
+}
+
+// TerminationMessage returns the message written to the termination log of the gitpod container,
+// which consists of the SHA and the committer date in unix seconds.
+func (c Commit) TerminationMessage() string {
+	return fmt.Sprintf("%s %d", c.SHA, c.Time.Unix())
+}
+
+// Sync clones the repository and copies the sources into the docs directory.
+// It returns the commit that has been synced.
+func (s *Syncer) Sync(ctx context.Context) (commit Commit, err error) {
+	c := s.Config
+
+	ctx, span := tracer.Start(ctx, "Sync", trace.WithAttributes(
+		attribute.String("git.repository", c.Repository.Normalized()),
+		attribute.String("git.branch", c.Branch),
+		attribute.Int("git.depth", c.Depth),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(attribute.String("git.commit", commit.SHA))
+		}
+		span.End()
+	}()
+
+	if err := os.RemoveAll(c.WorkDir); err != nil {
+		return Commit{}, err
+	}
+
+	args := []string{"clone", c.URL,
+		"--branch", c.Branch,
+		"--depth", strconv.Itoa(c.Depth),
+	}
+	if c.Submodules && c.SubmodulesRecursive {
+		args = append(args, "--recurse-submodules")
+		if c.SubmodulesShallow {
+			args = append(args, "--shallow-submodules")
+		}
+	}
+	args = append(args, c.WorkDir)
+
+	s.Log.Info("cloning repository", "url", c.Repository.String(), "repository", c.Repository.Normalized(), "branch", c.Branch, "depth", c.Depth)
+	if _, err := s.git(ctx, "", args...); err != nil {
+		return Commit{}, err
+	}
+
+	if c.Submodules && !c.SubmodulesRecursive {
+		args := []string{"submodule", "update", "--init"}
+		if c.SubmodulesShallow {
+			args = append(args, "--depth", "1")
+		}
+		s.Log.Info("updating submodules")
+		if _, err := s.git(ctx, c.WorkDir, args...); err != nil {
+			return Commit{}, err
+		}
+	}
+
+	out, err := s.git(ctx, c.WorkDir, "show", "--no-patch", "--format=%H %ct", "HEAD")
+	if err != nil {
+		return Commit{}, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return Commit{}, fmt.Errorf("unexpected output of git show: %q", out)
+	}
+	committed, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Commit{}, fmt.Errorf("invalid commit time %q: %w", fields[1], err)
+	}
+	commit = Commit{SHA: fields[0], Time: time.Unix(committed, 0)}
+
+	if err := cleanDir(c.DocsDir); err != nil {
+		return Commit{}, err
+	}
+	if err := copyTree(c.WorkDir, c.DocsDir); err != nil {
+		return Commit{}, err
+	}
+
+	s.Log.Info("synced sources", "commit", commit.SHA, "dir", c.DocsDir)
+	return commit, nil
+}
+
+func (s *Syncer) git(ctx context.Context, dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = s.env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	s.Log.V(1).Info("running git", "args", args)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// cleanDir removes the contents of dir, leaving dir itself since it is the mount point of the volume.
+func cleanDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDir copies the regular files directly under src into dst with the given permission.
+// It does nothing if src does not exist.
+func copyDir(src, dst string, perm os.FileMode) error {
+	entries, err := os.ReadDir(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// Follow the symlinks created by the projected volumes.
+		info, err := os.Stat(filepath.Join(src, e.Name()))
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dst, e.Name()), data, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies the working tree under src into dst except for the .git directory.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func fileExists(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func appendFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
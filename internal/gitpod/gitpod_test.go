@@ -0,0 +1,544 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitpod
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/git-ogawa/docserver/internal/giturl"
+)
+
+// runGit runs git in dir and returns the trimmed output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=docserver", "GIT_AUTHOR_EMAIL=docserver@example.com",
+		"GIT_COMMITTER_NAME=docserver", "GIT_COMMITTER_EMAIL=docserver@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newRepository creates a bare repository whose main branch has the files, and returns its file url and the SHA of the commit.
+func newRepository(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	bare := filepath.Join(dir, "repo.git")
+
+	runGit(t, dir, "init", "--initial-branch", "main", work)
+	for name, content := range files {
+		path := filepath.Join(work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-m", "initial commit")
+	runGit(t, dir, "clone", "--bare", work, bare)
+	return "file://" + bare, runGit(t, work, "rev-parse", "HEAD")
+}
+
+// newSyncer returns the Syncer of the repository whose directories are in the temporary directory.
+func newSyncer(t *testing.T, url string) *Syncer {
+	t.Helper()
+	repository, err := giturl.Parse(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	c := Config{
+		URL:        url,
+		Repository: repository,
+		Branch:     "main",
+		Depth:      1,
+		SSLVerify:  true,
+		DocsDir:    filepath.Join(dir, "docs"),
+		WorkDir:    filepath.Join(dir, "work"),
+		HomeDir:    filepath.Join(dir, "home"),
+	}
+	for _, d := range []string{c.DocsDir, c.HomeDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The submodules are cloned from the local repositories, and the git config of the host is not used.
+	t.Setenv("GIT_ALLOW_PROTOCOL", "file")
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	return &Syncer{Config: c, Log: logr.Discard()}
+}
+
+func TestSyncSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	url, sha := newRepository(t, map[string]string{"mkdocs.yml": "site_name: docs\n"})
+	s := newSyncer(t, url)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "gitpod")
+	if err := s.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	var sync sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "Sync" {
+			sync = span
+		}
+	}
+	if sync == nil {
+		t.Fatal("Sync span is not recorded")
+	}
+	if got, want := sync.Parent().SpanID(), parent.SpanContext().SpanID(); got != want {
+		t.Errorf("parent of Sync span = %s, want %s", got, want)
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range sync.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	if got := attrs["git.commit"].AsString(); got != sha {
+		t.Errorf("git.commit = %q, want %q", got, sha)
+	}
+	if got, want := attrs["git.repository"].AsString(), s.Config.Repository.Normalized(); got != want {
+		t.Errorf("git.repository = %q, want %q", got, want)
+	}
+	if got := attrs["git.branch"].AsString(); got != "main" {
+		t.Errorf("git.branch = %q, want main", got)
+	}
+}
+
+func TestPrepareSSH(t *testing.T) {
+	strict := true
+	tests := []struct {
+		name       string
+		secret     map[string]string
+		strict     *bool
+		identities []string
+		want       string
+	}{
+		{
+			name:   "no private key",
+			strict: &strict,
+			want:   "ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile={ssh}/known_hosts",
+		},
+		{
+			name:       "id_ed25519",
+			secret:     map[string]string{"id_ed25519": "key", "id_ed25519.pub": "pub", "known_hosts": "github.com ssh-ed25519 AAAA"},
+			strict:     &strict,
+			identities: []string{"id_ed25519"},
+			want:       "ssh -i {ssh}/id_ed25519 -o IdentitiesOnly=yes -o StrictHostKeyChecking=yes -o UserKnownHostsFile={ssh}/known_hosts",
+		},
+		{
+			name:       "identity and sshPrivateKey",
+			secret:     map[string]string{"identity": "key", "sshPrivateKey": "key"},
+			identities: []string{"identity", "sshPrivateKey"},
+			want:       "ssh -i {ssh}/identity -i {ssh}/sshPrivateKey -o IdentitiesOnly=yes",
+		},
+		{
+			name:   "other keys",
+			secret: map[string]string{"config": "Host *"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSyncer(t, "git@github.com:git-ogawa/docserver.git")
+			s.Config.MountDir = t.TempDir()
+			s.Config.StrictHostKeyChecking = tt.strict
+			dir := filepath.Join(s.Config.MountDir, privateKeyDir)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.secret {
+				if err := os.WriteFile(filepath.Join(dir, k), []byte(v), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.prepareSSH(); err != nil {
+				t.Fatal(err)
+			}
+
+			sshDir := filepath.Join(s.Config.HomeDir, ".ssh")
+			var got string
+			for _, e := range s.env {
+				if strings.HasPrefix(e, "GIT_SSH_COMMAND=") {
+					got = strings.TrimPrefix(e, "GIT_SSH_COMMAND=")
+				}
+			}
+			if want := strings.ReplaceAll(tt.want, "{ssh}", sshDir); got != want {
+				t.Errorf("GIT_SSH_COMMAND = %q, want %q", got, want)
+			}
+			for _, name := range tt.identities {
+				info, err := os.Stat(filepath.Join(sshDir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if perm := info.Mode().Perm(); perm != 0600 {
+					t.Errorf("permission of %s = %o, want 600", name, perm)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    func(c Config) bool
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"GIT_URL": "https://github.com/git-ogawa/docserver.git"},
+			want: func(c Config) bool {
+				return c.Branch == "main" && c.Depth == 1 && c.SSLVerify && c.StrictHostKeyChecking == nil &&
+					c.Repository.Normalized() == "github.com/git-ogawa/docserver"
+			},
+		},
+		{
+			name: "ssh",
+			env: map[string]string{
+				"GIT_URL":                          "git@github.com:git-ogawa/docserver.git",
+				"GIT_BRANCH":                       "develop",
+				"GIT_DEPTH":                        "10",
+				"GIT_SSH_STRICT_HOST_KEY_CHECKING": "false",
+			},
+			want: func(c Config) bool {
+				return c.Repository.IsSSH() && c.Branch == "develop" && c.Depth == 10 &&
+					c.StrictHostKeyChecking != nil && !*c.StrictHostKeyChecking
+			},
+		},
+		{
+			name: "credentials",
+			env: map[string]string{
+				"GIT_URL":        "https://git.example.com/docs.git",
+				"GIT_USERNAME":   "docs",
+				"GIT_PASSWORD":   "secret",
+				"GIT_SSL_VERIFY": "false",
+			},
+			want: func(c Config) bool {
+				return c.Username == "docs" && c.Password == "secret" && !c.SSLVerify
+			},
+		},
+		{
+			name:    "invalid url",
+			env:     map[string]string{"GIT_URL": "github.com"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid depth",
+			env:     map[string]string{"GIT_URL": "https://github.com/git-ogawa/docserver.git", "GIT_DEPTH": "one"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid strict host key checking",
+			env:     map[string]string{"GIT_URL": "git@github.com:git-ogawa/docserver.git", "GIT_SSH_STRICT_HOST_KEY_CHECKING": "maybe"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"GIT_BRANCH", "GIT_DEPTH", "GIT_SSL_VERIFY", "GIT_USERNAME", "GIT_PASSWORD", "GIT_SSH_STRICT_HOST_KEY_CHECKING"} {
+				t.Setenv(k, "")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c, err := ConfigFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConfigFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !tt.want(c) {
+				t.Errorf("ConfigFromEnv() = %+v", c)
+			}
+		})
+	}
+}
+
+func TestPrepareGitConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		username  string
+		password  string
+		sslVerify bool
+		// want is the output of git credential fill, or empty if the helper is not configured.
+		want          string
+		wantSSLVerify string
+	}{
+		{
+			name:      "no credentials",
+			sslVerify: true,
+		},
+		{
+			name:      "credentials",
+			username:  "docs",
+			password:  "secret",
+			sslVerify: true,
+			want:      "username=docs\npassword=secret",
+		},
+		{
+			name:          "ssl verify disabled",
+			sslVerify:     false,
+			wantSSLVerify: "false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The helper reads the credentials from the environment variables of the container.
+			t.Setenv("GIT_USERNAME", tt.username)
+			t.Setenv("GIT_PASSWORD", tt.password)
+			t.Setenv("GIT_TERMINAL_PROMPT", "0")
+			s := newSyncer(t, "https://git.example.com/docs.git")
+			s.Config.Username = tt.username
+			s.Config.Password = tt.password
+			s.Config.SSLVerify = tt.sslVerify
+			s.Config.MountDir = t.TempDir()
+			if err := s.Prepare(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command("git", "credential", "fill")
+			cmd.Env = s.env
+			cmd.Stdin = strings.NewReader("protocol=https\nhost=git.example.com\n\n")
+			out, err := cmd.Output()
+			got := ""
+			if err == nil {
+				got = strings.TrimSpace(strings.TrimPrefix(string(out), "protocol=https\nhost=git.example.com\n"))
+			}
+			if got != tt.want {
+				t.Errorf("git credential fill = %q, want %q", got, tt.want)
+			}
+
+			cmd = exec.Command("git", "config", "--get", "http.sslVerify")
+			cmd.Env = s.env
+			out, _ = cmd.Output()
+			if got := strings.TrimSpace(string(out)); got != tt.wantSSLVerify {
+				t.Errorf("http.sslVerify = %q, want %q", got, tt.wantSSLVerify)
+			}
+		})
+	}
+}
+
+func TestPrepareKnownHosts(t *testing.T) {
+	const (
+		secretHost    = "secret.example.com ssh-ed25519 AAAA1"
+		inlineHost    = "inline.example.com ssh-ed25519 AAAA2"
+		configMapHost = "configmap.example.com ssh-ed25519 AAAA3"
+	)
+	tests := []struct {
+		name      string
+		secret    string
+		inline    string
+		configMap string
+		want      []string
+	}{
+		{name: "none"},
+		{name: "secret", secret: secretHost, want: []string{secretHost}},
+		{name: "inline", inline: inlineHost, want: []string{inlineHost}},
+		{name: "configmap", configMap: configMapHost, want: []string{configMapHost}},
+		{
+			name:   "secret and inline",
+			secret: secretHost, inline: inlineHost,
+			want: []string{secretHost, inlineHost},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSyncer(t, "git@github.com:git-ogawa/docserver.git")
+			s.Config.MountDir = t.TempDir()
+			s.Config.KnownHosts = tt.inline
+			for dir, content := range map[string]string{privateKeyDir: tt.secret, knownHostsDir: tt.configMap} {
+				if len(content) == 0 {
+					continue
+				}
+				if err := os.MkdirAll(filepath.Join(s.Config.MountDir, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(s.Config.MountDir, dir, "known_hosts"), []byte(content+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.prepareSSH(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(s.Config.HomeDir, ".ssh", "known_hosts"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range strings.Split(string(data), "\n") {
+				if len(line) != 0 {
+					got = append(got, line)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("known_hosts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	subURL, _ := newRepository(t, map[string]string{"README.md": "submodule\n"})
+
+	tests := []struct {
+		name   string
+		files  map[string]string
+		config func(c *Config)
+		// submodule adds the submodule to the repository at the path.
+		submodule string
+		want      []string
+		wantNot   []string
+	}{
+		{
+			name:    "plain",
+			files:   map[string]string{"mkdocs.yml": "site_name: docs\n", "docs/index.md": "# docs\n"},
+			want:    []string{"mkdocs.yml", "docs/index.md"},
+			wantNot: []string{".git"},
+		},
+		{
+			name:      "submodules not enabled",
+			files:     map[string]string{"mkdocs.yml": "site_name: docs\n"},
+			submodule: "docs/sub",
+			wantNot:   []string{"docs/sub/README.md"},
+		},
+		{
+			name:      "recursive submodules",
+			files:     map[string]string{"mkdocs.yml": "site_name: docs\n"},
+			submodule: "docs/sub",
+			config: func(c *Config) {
+				c.Submodules, c.SubmodulesRecursive, c.SubmodulesShallow = true, true, true
+			},
+			want: []string{"mkdocs.yml", "docs/sub/README.md"},
+		},
+		{
+			name:      "non-recursive submodules",
+			files:     map[string]string{"mkdocs.yml": "site_name: docs\n"},
+			submodule: "docs/sub",
+			config: func(c *Config) {
+				c.Submodules = true
+			},
+			want: []string{"mkdocs.yml", "docs/sub/README.md"},
+		},
+		{
+			name:  "lfs",
+			files: map[string]string{"mkdocs.yml": "site_name: docs\n", ".gitattributes": "*.png filter=lfs diff=lfs merge=lfs -text\n"},
+			config: func(c *Config) {
+				c.LFS = true
+			},
+			want: []string{"mkdocs.yml", ".gitattributes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSyncer(t, "file:///placeholder.git")
+			if tt.config != nil {
+				tt.config(&s.Config)
+			}
+			if s.Config.LFS {
+				if _, err := exec.LookPath("git-lfs"); err != nil {
+					t.Skip("git-lfs is not installed")
+				}
+			}
+
+			url, sha := newRepository(t, tt.files)
+			if len(tt.submodule) != 0 {
+				sha = addSubmodule(t, url, subURL, tt.submodule)
+			}
+			repository, err := giturl.Parse(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Config.URL, s.Config.Repository = url, repository
+			s.Config.MountDir = t.TempDir()
+
+			if err := s.Prepare(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			commit, err := s.Sync(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if commit.SHA != sha {
+				t.Errorf("SHA = %s, want %s", commit.SHA, sha)
+			}
+			fields := strings.Fields(commit.TerminationMessage())
+			if len(fields) != 2 || fields[0] != sha || fields[1] != strconv.FormatInt(commit.Time.Unix(), 10) {
+				t.Errorf("TerminationMessage() = %q, want %q and the committer date", commit.TerminationMessage(), sha)
+			}
+			for _, name := range tt.want {
+				if _, err := os.Stat(filepath.Join(s.Config.DocsDir, name)); err != nil {
+					t.Errorf("%s is not synced: %v", name, err)
+				}
+			}
+			for _, name := range tt.wantNot {
+				if _, err := os.Stat(filepath.Join(s.Config.DocsDir, name)); err == nil {
+					t.Errorf("%s is synced", name)
+				}
+			}
+		})
+	}
+}
+
+// addSubmodule adds the submodule to the bare repository at the path, and returns the SHA of the new commit.
+func addSubmodule(t *testing.T, url, subURL, path string) string {
+	t.Helper()
+	work := filepath.Join(t.TempDir(), "work")
+	runGit(t, "", "clone", url, work)
+	runGit(t, work, "-c", "protocol.file.allow=always", "submodule", "add", subURL, path)
+	runGit(t, work, "commit", "-m", "add submodule")
+	runGit(t, work, "push", "origin", "main")
+	return runGit(t, work, "rev-parse", "HEAD")
+}
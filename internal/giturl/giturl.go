@@ -0,0 +1,172 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package giturl parses the urls of git repositories, which is shared by the webhook and the gitpod.
+package giturl
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The schemes of the urls supported by git.
+const (
+	SchemeHTTPS = "https"
+	SchemeHTTP  = "http"
+	SchemeSSH   = "ssh"
+	SchemeGit   = "git"
+	SchemeFile  = "file"
+)
+
+var defaultPorts = map[string]string{
+	SchemeHTTPS: "443",
+	SchemeHTTP:  "80",
+	SchemeSSH:   "22",
+	SchemeGit:   "9418",
+}
+
+// scpRegexp matches the scp-like syntax of ssh urls such as git@github.com:org/repo.git.
+var scpRegexp = regexp.MustCompile(`^(?:([^@/:]+)@)?([^@/:]+):([^/].*)$`)
+
+// URL is the parsed url of a git repository.
+type URL struct {
+	// Scheme is one of https, http, ssh, git and file. The scp-like urls have ssh scheme.
+	Scheme string
+	// User is the user name of the url, e.g. git in git@github.com:org/repo.git.
+	User string
+	// Host is the host of the url including the port if set. It is empty for file urls.
+	Host string
+	// Path is the path to the repository, e.g. org/repo.git.
+	Path string
+	// SCP is true if the url is written in the scp-like syntax.
+	SCP bool
+}
+
+// Parse parses the url of a git repository. The following forms are supported.
+//
+//	https://github.com/org/repo.git
+//	http://git.example.com/org/repo
+//	ssh://git@github.com:22/org/repo.git
+//	git@github.com:org/repo.git
+//	git://git.example.com/org/repo.git
+//	file:///path/to/repo.git
+func Parse(raw string) (*URL, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("url is empty")
+	}
+
+	if !strings.Contains(raw, "://") {
+		m := scpRegexp.FindStringSubmatch(raw)
+		if m == nil {
+			return nil, fmt.Errorf("url %q is neither a url with scheme nor scp-like ssh url", raw)
+		}
+		return &URL{
+			Scheme: SchemeSSH,
+			User:   m[1],
+			Host:   m[2],
+			Path:   m[3],
+			SCP:    true,
+		}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &URL{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   u.Host,
+		Path:   strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		parsed.User = u.User.Username()
+	}
+
+	switch parsed.Scheme {
+	case SchemeHTTPS, SchemeHTTP, SchemeSSH, SchemeGit:
+		if len(u.Hostname()) == 0 {
+			return nil, fmt.Errorf("url %q has no host", raw)
+		}
+	case SchemeFile:
+		if len(parsed.Host) != 0 {
+			return nil, fmt.Errorf("file url %q must not have host", raw)
+		}
+		parsed.Path = u.Path
+	default:
+		return nil, fmt.Errorf("scheme %q of url %q is not supported", u.Scheme, raw)
+	}
+
+	if len(strings.Trim(parsed.Path, "/")) == 0 {
+		return nil, fmt.Errorf("url %q has no repository path", raw)
+	}
+	return parsed, nil
+}
+
+// IsSSH returns whether or not the url is accessed with ssh.
+func (u *URL) IsSSH() bool {
+	return u.Scheme == SchemeSSH
+}
+
+// IsHTTP returns whether or not the url is accessed with http or https.
+func (u *URL) IsHTTP() bool {
+	return u.Scheme == SchemeHTTPS || u.Scheme == SchemeHTTP
+}
+
+// Hostname returns the host without the port.
+func (u *URL) Hostname() string {
+	if u.SCP {
+		return u.Host
+	}
+	return (&url.URL{Host: u.Host}).Hostname()
+}
+
+// Normalized returns the form of the url that identifies the repository in the logs and the span attributes
+// regardless of how the url is written.
+// The scheme, user, default port and .git suffix are removed and the host is lowercased,
+// e.g. both https://GitHub.com/org/repo.git and git@github.com:org/repo are normalized to github.com/org/repo.
+func (u *URL) Normalized() string {
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if u.Scheme == SchemeFile {
+		return "/" + path
+	}
+
+	host := strings.ToLower(u.Host)
+	if !u.SCP {
+		h := &url.URL{Host: host}
+		if h.Port() == defaultPorts[u.Scheme] {
+			host = h.Hostname()
+		}
+	}
+	return host + "/" + path
+}
+
+// String returns the url without the password.
+func (u *URL) String() string {
+	user := ""
+	if len(u.User) != 0 {
+		user = u.User + "@"
+	}
+	if u.SCP {
+		return user + u.Host + ":" + u.Path
+	}
+	if u.Scheme == SchemeFile {
+		return "file://" + u.Path
+	}
+	return u.Scheme + "://" + user + u.Host + "/" + u.Path
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health implements the readiness checks of the controller manager.
+package health
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// checkTimeout is the time a check waits for the informers to sync.
+const checkTimeout = time.Second
+
+// CacheSynced returns the checker that fails until the informers of all the objs have synced.
+// The informers that have not been created yet are created by the check, so that the standby
+// replicas warm up their cache before they become the leader.
+func CacheSynced(c cache.Cache, objs ...client.Object) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+
+		for _, obj := range objs {
+			informer, err := c.GetInformer(ctx, obj)
+			if err != nil {
+				return fmt.Errorf("informer for %T has not synced: %w", obj, err)
+			}
+			if !informer.HasSynced() {
+				return fmt.Errorf("informer for %T has not synced", obj)
+			}
+		}
+		return nil
+	}
+}
+
+// WebhookServing returns the checker that fails until the webhook server accepts the connections
+// with the serving certificate that is currently valid.
+func WebhookServing(server *webhook.Server) healthz.Checker {
+	started := server.StartedChecker()
+	return func(req *http.Request) error {
+		if err := started(req); err != nil {
+			return err
+		}
+
+		// CertDir and CertName are defaulted by the server before it is started.
+		path := filepath.Join(server.CertDir, server.CertName)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read webhook certificate: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("no PEM data found in webhook certificate %s", path)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid webhook certificate %s: %w", path, err)
+		}
+		now := time.Now()
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return fmt.Errorf("webhook certificate %s is valid only from %s to %s", path, cert.NotBefore, cert.NotAfter)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func TestCacheSynced(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+	informers := &informertest.FakeInformers{}
+	secrets, err := informers.FakeInformerFor(&corev1.Secret{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMaps, err := informers.FakeInformerFor(&corev1.ConfigMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := CacheSynced(informers, &corev1.Secret{}, &corev1.ConfigMap{})
+
+	secrets.Synced = true
+	if err := check(req); err == nil || !strings.Contains(err.Error(), "*v1.ConfigMap has not synced") {
+		t.Errorf("check() error = %v, want the ConfigMap informer not to have synced", err)
+	}
+
+	configMaps.Synced = true
+	if err := check(req); err != nil {
+		t.Errorf("check() error = %v, want nil", err)
+	}
+
+	check = CacheSynced(&informertest.FakeInformers{Error: errors.New("forbidden")}, &corev1.Secret{})
+	if err := check(req); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("check() error = %v, want the informer not to be created", err)
+	}
+}
+
+// writeCertificate writes the self-signed certificate valid from notBefore to notAfter, and its key, into dir.
+func writeCertificate(t *testing.T, dir string, notBefore, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tls.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// freePort returns the port that is not used on the loopback address.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestWebhookServing(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+	dir := t.TempDir()
+	now := time.Now()
+	writeCertificate(t, dir, now.Add(-time.Hour), now.Add(time.Hour))
+
+	server := &webhook.Server{Host: "127.0.0.1", Port: freePort(t), CertDir: dir}
+	check := WebhookServing(server)
+	if err := check(req); err == nil || !strings.Contains(err.Error(), "not been started") {
+		t.Errorf("check() error = %v, want the server not to have started", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := server.Start(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for err := check(req); err != nil; err = check(req) {
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook server is not ready: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name     string
+		prepare  func(t *testing.T)
+		expected string
+	}{
+		{
+			name: "expired",
+			prepare: func(t *testing.T) {
+				writeCertificate(t, dir, now.Add(-2*time.Hour), now.Add(-time.Hour))
+			},
+			expected: "is valid only from",
+		},
+		{
+			name: "not yet valid",
+			prepare: func(t *testing.T) {
+				writeCertificate(t, dir, now.Add(time.Hour), now.Add(2*time.Hour))
+			},
+			expected: "is valid only from",
+		},
+		{
+			name: "invalid",
+			prepare: func(t *testing.T) {
+				if err := os.WriteFile(filepath.Join(dir, "tls.crt"), []byte("invalid"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expected: "no PEM data found",
+		},
+		{
+			name: "missing",
+			prepare: func(t *testing.T) {
+				if err := os.Remove(filepath.Join(dir, "tls.crt")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expected: "unable to read webhook certificate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.prepare(t)
+			if err := check(req); err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("check() error = %v, want %q", err, tt.expected)
+			}
+		})
+	}
+}
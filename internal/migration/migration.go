@@ -0,0 +1,161 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package migration migrates the stored custom resources to the storage version of their CRD.
+package migration
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get
+//+kubebuilder:rbac:groups=update.git-ogawa.github.io,resources=docserverclasses,verbs=update
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=get;update
+
+var crdGVK = schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}
+
+// DefaultBackoff retries the migration for about 5 minutes.
+var DefaultBackoff = wait.Backoff{
+	Duration: 5 * time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    7,
+}
+
+// StorageVersionMigrator rewrites the stored objects of the resources so that they are stored in the storage version,
+// and then drops the other versions from status.storedVersions of their CRDs, which allows the old versions to be removed.
+// The conversion webhook must be served while it runs, so it retries until the webhook becomes available.
+type StorageVersionMigrator struct {
+	// Client updates the objects and the CRDs.
+	Client client.Client
+	// Reader lists the objects. It should not be backed by the cache since the objects are read as unstructured.
+	Reader client.Reader
+	// Resources is the group resources to migrate, such as docservers.update.git-ogawa.github.io.
+	Resources []schema.GroupResource
+	// Backoff is the retries of the migration of each resource.
+	Backoff wait.Backoff
+	Log     logr.Logger
+}
+
+var _ manager.LeaderElectionRunnable = &StorageVersionMigrator{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable so that only the leader migrates the objects.
+func (m *StorageVersionMigrator) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable. The failures are logged, and the migration is retried on the next start.
+func (m *StorageVersionMigrator) Start(ctx context.Context) error {
+	for _, gr := range m.Resources {
+		log := m.Log.WithValues("resource", gr.String())
+		var lastErr error
+		err := wait.ExponentialBackoffWithContext(ctx, m.Backoff, func() (bool, error) {
+			lastErr = m.migrate(ctx, log, gr)
+			if lastErr != nil {
+				log.Info("storage version migration failed, retrying", "error", lastErr.Error())
+				return false, nil
+			}
+			return true, nil
+		})
+		if err != nil {
+			if lastErr != nil {
+				err = lastErr
+			}
+			log.Error(err, "unable to migrate storage version")
+		}
+	}
+	return nil
+}
+
+func (m *StorageVersionMigrator) migrate(ctx context.Context, log logr.Logger, gr schema.GroupResource) error {
+	crd := &unstructured.Unstructured{}
+	crd.SetGroupVersionKind(crdGVK)
+	if err := m.Client.Get(ctx, client.ObjectKey{Name: gr.String()}, crd); err != nil {
+		return err
+	}
+
+	storageVersion, kind, err := storageVersionOf(crd)
+	if err != nil {
+		return err
+	}
+	storedVersions, _, err := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	if err != nil {
+		return err
+	}
+	if len(storedVersions) == 1 && storedVersions[0] == storageVersion {
+		return nil
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(schema.GroupVersionKind{Group: gr.Group, Version: storageVersion, Kind: kind + "List"})
+	if err := m.Reader.List(ctx, list); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		obj := &list.Items[i]
+		// The update without changes makes the API server store the object in the storage version.
+		err := m.Client.Update(ctx, obj)
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+			// The object has been deleted or written in the storage version by others.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to migrate %s/%s: %w", obj.GetNamespace(), obj.GetName(), err)
+		}
+	}
+
+	if err := unstructured.SetNestedStringSlice(crd.Object, []string{storageVersion}, "status", "storedVersions"); err != nil {
+		return err
+	}
+	if err := m.Client.Status().Update(ctx, crd); err != nil {
+		return err
+	}
+	log.Info("migrated storage version", "storageVersion", storageVersion, "previousStoredVersions", storedVersions, "objects", len(list.Items))
+	return nil
+}
+
+// storageVersionOf returns the storage version and the kind of the CRD.
+func storageVersionOf(crd *unstructured.Unstructured) (string, string, error) {
+	kind, _, err := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	if err != nil {
+		return "", "", err
+	}
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return "", "", err
+	}
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if storage, _ := version["storage"].(bool); storage {
+			name, _ := version["name"].(string)
+			return name, kind, nil
+		}
+	}
+	return "", "", fmt.Errorf("no storage version in %s", crd.GetName())
+}
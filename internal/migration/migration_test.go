@@ -0,0 +1,183 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func widgetVersion(name string, storage bool) apiextensionsv1.CustomResourceDefinitionVersion {
+	return apiextensionsv1.CustomResourceDefinitionVersion{
+		Name:    name,
+		Served:  true,
+		Storage: storage,
+		Schema: &apiextensionsv1.CustomResourceValidation{
+			OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+				Type:                   "object",
+				XPreserveUnknownFields: pointer.Bool(true),
+			},
+		},
+	}
+}
+
+var _ = Describe("StorageVersionMigrator", Ordered, func() {
+	ctx := context.Background()
+	gr := schema.GroupResource{Group: "migration.example.com", Resource: "widgets"}
+
+	It("rewrites the objects and drops the old stored versions", func() {
+		By("creating the CRD stored in v1alpha1")
+		crd := &apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: gr.String()},
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Group: gr.Group,
+				Names: apiextensionsv1.CustomResourceDefinitionNames{
+					Kind:     "Widget",
+					ListKind: "WidgetList",
+					Plural:   "widgets",
+					Singular: "widget",
+				},
+				Scope:    apiextensionsv1.NamespaceScoped,
+				Versions: []apiextensionsv1.CustomResourceDefinitionVersion{widgetVersion("v1alpha1", true), widgetVersion("v1", false)},
+			},
+		}
+		Expect(k8sClient.Create(ctx, crd)).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(crd), crd)).To(Succeed())
+			established := false
+			for _, cond := range crd.Status.Conditions {
+				if cond.Type == apiextensionsv1.Established && cond.Status == apiextensionsv1.ConditionTrue {
+					established = true
+				}
+			}
+			g.Expect(established).To(BeTrue())
+		}).Should(Succeed())
+
+		widget := &unstructured.Unstructured{}
+		widget.SetGroupVersionKind(schema.GroupVersionKind{Group: gr.Group, Version: "v1alpha1", Kind: "Widget"})
+		widget.SetNamespace("default")
+		widget.SetName("widget")
+		Eventually(func() error {
+			return k8sClient.Create(ctx, widget)
+		}).Should(Succeed())
+
+		By("switching the storage version to v1")
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(crd), crd)).To(Succeed())
+		crd.Spec.Versions = []apiextensionsv1.CustomResourceDefinitionVersion{widgetVersion("v1alpha1", false), widgetVersion("v1", true)}
+		Expect(k8sClient.Update(ctx, crd)).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(crd), crd)).To(Succeed())
+			g.Expect(crd.Status.StoredVersions).To(ConsistOf("v1alpha1", "v1"))
+		}).Should(Succeed())
+
+		By("running the migrator")
+		migrator := &StorageVersionMigrator{
+			Client:    k8sClient,
+			Reader:    k8sClient,
+			Resources: []schema.GroupResource{gr},
+			Backoff:   wait.Backoff{Duration: 100 * time.Millisecond, Factor: 2, Steps: 5},
+			Log:       logf.Log.WithName("migration"),
+		}
+		Expect(migrator.Start(ctx)).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(crd), crd)).To(Succeed())
+		Expect(crd.Status.StoredVersions).To(Equal([]string{"v1"}))
+
+		By("reading the object in the new storage version")
+		migrated := &unstructured.Unstructured{}
+		migrated.SetGroupVersionKind(schema.GroupVersionKind{Group: gr.Group, Version: "v1", Kind: "Widget"})
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(widget), migrated)).To(Succeed())
+		Expect(migrated.GetResourceVersion()).NotTo(Equal(widget.GetResourceVersion()))
+	})
+
+	It("does nothing when only the storage version is stored", func() {
+		migrator := &StorageVersionMigrator{
+			Client:    k8sClient,
+			Reader:    k8sClient,
+			Resources: []schema.GroupResource{gr},
+			Backoff:   wait.Backoff{Duration: 100 * time.Millisecond, Factor: 2, Steps: 1},
+			Log:       logf.Log.WithName("migration"),
+		}
+		Expect(migrator.migrate(ctx, migrator.Log, gr)).To(Succeed())
+	})
+})
+
+func TestStorageVersionOf(t *testing.T) {
+	version := func(name string, storage bool) interface{} {
+		return map[string]interface{}{"name": name, "served": true, "storage": storage}
+	}
+	newCRD := func(versions ...interface{}) *unstructured.Unstructured {
+		crd := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"names":    map[string]interface{}{"kind": "DocServer"},
+				"versions": versions,
+			},
+		}}
+		crd.SetName("docservers.update.git-ogawa.github.io")
+		return crd
+	}
+
+	tests := []struct {
+		name     string
+		crd      *unstructured.Unstructured
+		expected string
+		err      string
+	}{
+		{"storage version first", newCRD(version("v1", true), version("v1beta1", false)), "v1", ""},
+		{"storage version last", newCRD(version("v1beta1", false), version("v1", true)), "v1", ""},
+		{"no storage version", newCRD(version("v1beta1", false)), "", "no storage version in docservers.update.git-ogawa.github.io"},
+		{"no versions", newCRD(), "", "no storage version in docservers.update.git-ogawa.github.io"},
+		{"malformed version", newCRD("v1", version("v1beta1", true)), "v1beta1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, kind, err := storageVersionOf(tt.crd)
+			if len(tt.err) != 0 {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("storageVersionOf() error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tt.expected || kind != "DocServer" {
+				t.Errorf("storageVersionOf() = %s, %s, want %s, DocServer", actual, kind, tt.expected)
+			}
+		})
+	}
+
+	malformed := newCRD(version("v1", true))
+	malformed.Object["spec"].(map[string]interface{})["versions"] = "v1"
+	if _, _, err := storageVersionOf(malformed); err == nil {
+		t.Error("storageVersionOf() of the malformed versions succeeds, want error")
+	}
+}
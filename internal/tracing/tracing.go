@@ -0,0 +1,160 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing sets up the OpenTelemetry tracing of the controller and the gitpod Job,
+// and propagates the trace context from the controller to the Job through the environment variables.
+package tracing
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	// EndpointEnv is the environment variable of the OTLP gRPC endpoint given to the gitpod Job.
+	EndpointEnv = "OTLP_ENDPOINT"
+	// InsecureEnv is the environment variable that disables the TLS of the OTLP exporter.
+	InsecureEnv = "OTLP_INSECURE"
+	// SamplingRatioEnv is the environment variable of the sampling ratio of the traces.
+	SamplingRatioEnv = "TRACE_SAMPLING_RATIO"
+)
+
+// propagator propagates the W3C trace context and baggage.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Options is the configuration of the tracing.
+type Options struct {
+	// Endpoint is the host and port of the OTLP gRPC collector. The tracing is disabled when it is empty.
+	Endpoint string
+	// Insecure disables the TLS of the connection to the collector.
+	Insecure bool
+	// SamplingRatio is the ratio of the traces sampled at the root span.
+	SamplingRatio float64
+	// ServiceName is the name of the service recorded in the resource of the spans.
+	ServiceName string
+	// Exporter overrides the OTLP exporter, e.g. with the in-memory exporter in tests.
+	Exporter sdktrace.SpanExporter
+}
+
+// Enabled returns true if the spans are exported.
+func (o Options) Enabled() bool {
+	return len(o.Endpoint) != 0 || o.Exporter != nil
+}
+
+// Env returns the environment variables that configure the tracing of the gitpod Job in the same way.
+func (o Options) Env() map[string]string {
+	if len(o.Endpoint) == 0 {
+		return nil
+	}
+	return map[string]string{
+		EndpointEnv:      o.Endpoint,
+		InsecureEnv:      strconv.FormatBool(o.Insecure),
+		SamplingRatioEnv: strconv.FormatFloat(o.SamplingRatio, 'f', -1, 64),
+	}
+}
+
+// OptionsFromEnv returns the Options read from the environment variables set by Env.
+func OptionsFromEnv(serviceName string) Options {
+	o := Options{
+		Endpoint:      os.Getenv(EndpointEnv),
+		Insecure:      os.Getenv(InsecureEnv) == "true",
+		SamplingRatio: 1,
+		ServiceName:   serviceName,
+	}
+	if ratio, err := strconv.ParseFloat(os.Getenv(SamplingRatioEnv), 64); err == nil {
+		o.SamplingRatio = ratio
+	}
+	return o
+}
+
+// Setup installs the global TracerProvider and propagator. The returned function flushes and stops the exporter.
+// It does nothing when the tracing is disabled, so that the global no-op TracerProvider is used.
+func Setup(ctx context.Context, o Options) (func(context.Context) error, error) {
+	if !o.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter := o.Exporter
+	if exporter == nil {
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(o.Endpoint)}
+		if o.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		var err error
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(o.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(o.SamplingRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+// InjectEnv returns the environment variables carrying the trace context of ctx, e.g. TRACEPARENT.
+func InjectEnv(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	env := make(map[string]string, len(carrier))
+	for k, v := range carrier {
+		env[strings.ToUpper(k)] = v
+	}
+	return env
+}
+
+// PropagatedEnv returns the names of the environment variables set by InjectEnv.
+func PropagatedEnv() []string {
+	var names []string
+	for _, k := range propagator.Fields() {
+		names = append(names, strings.ToUpper(k))
+	}
+	return names
+}
+
+// ExtractEnv returns the context with the trace context read from the environment variables set by InjectEnv.
+func ExtractEnv(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{}
+	for _, k := range propagator.Fields() {
+		if v, ok := os.LookupEnv(strings.ToUpper(k)); ok {
+			carrier.Set(k, v)
+		}
+	}
+	return propagator.Extract(ctx, carrier)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// keepExporter keeps the spans on shutdown, which are reset by InMemoryExporter.
+type keepExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (keepExporter) Shutdown(context.Context) error { return nil }
+
+func TestPropagation(t *testing.T) {
+	exporter := keepExporter{tracetest.NewInMemoryExporter()}
+	shutdown, err := Setup(context.Background(), Options{ServiceName: "test", SamplingRatio: 1, Exporter: exporter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := otel.Tracer("controller").Start(context.Background(), "reconcileJob")
+	env := InjectEnv(ctx)
+	parent.End()
+	if len(env["TRACEPARENT"]) == 0 {
+		t.Fatalf("InjectEnv() = %v, want TRACEPARENT", env)
+	}
+	for _, name := range PropagatedEnv() {
+		t.Setenv(name, env[name])
+	}
+
+	_, child := otel.Tracer("gitpod").Start(ExtractEnv(context.Background()), "gitpod")
+	child.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	byName := map[string]tracetest.SpanStub{}
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	if got, want := byName["gitpod"].Parent.SpanID(), byName["reconcileJob"].SpanContext.SpanID(); got != want {
+		t.Errorf("parent of gitpod span = %s, want %s", got, want)
+	}
+	if got, want := byName["gitpod"].SpanContext.TraceID(), byName["reconcileJob"].SpanContext.TraceID(); got != want {
+		t.Errorf("trace of gitpod span = %s, want %s", got, want)
+	}
+	if !byName["gitpod"].Parent.IsRemote() {
+		t.Error("parent of gitpod span is not remote")
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	provider := otel.GetTracerProvider()
+	shutdown, err := Setup(context.Background(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if otel.GetTracerProvider() != provider {
+		t.Error("Setup() installed TracerProvider while tracing is disabled")
+	}
+}
+
+func TestOptionsEnv(t *testing.T) {
+	o := Options{Endpoint: "otel-collector:4317", Insecure: true, SamplingRatio: 0.5, ServiceName: "docserver-gitpod"}
+	for k, v := range o.Env() {
+		t.Setenv(k, v)
+	}
+	if got := OptionsFromEnv("docserver-gitpod"); got != o {
+		t.Errorf("OptionsFromEnv() = %+v, want %+v", got, o)
+	}
+	if env := (Options{}).Env(); env != nil {
+		t.Errorf("Env() of disabled tracing = %v, want nil", env)
+	}
+}